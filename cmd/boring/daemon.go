@@ -95,15 +95,26 @@ func sendCmd(cmd daemon.Cmd) (*daemon.Resp, error) {
 	}
 	defer conn.Close()
 
-	var resp daemon.Resp
 	if err := ipc.Write(cmd, conn); err != nil {
 		return nil, err
 	}
-	if err := ipc.Read(&resp, conn); err != nil {
-		return nil, err
+	for {
+		var resp daemon.Resp
+		if err := ipc.Read(&resp, conn); err != nil {
+			return nil, err
+		}
+		if resp.Prompt == nil {
+			return &resp, nil
+		}
+		// Answer prompts until the final response arrives
+		ans, err := answer(resp.Prompt)
+		if err != nil {
+			return nil, err
+		}
+		if err := ipc.Write(daemon.Cmd{Kind: daemon.Answer, Answer: ans}, conn); err != nil {
+			return nil, err
+		}
 	}
-
-	return &resp, nil
 }
 
 // probeDaemon checks whether a daemon on the default socket is responsive,
@@ -0,0 +1,87 @@
+//
+// Listing and removing entries of known hosts files.
+//
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/ssh_config"
+	"github.com/alebeck/boring/internal/table"
+	"golang.org/x/crypto/ssh"
+)
+
+func knownHosts(args []string) {
+	if len(args) == 0 {
+		log.Fatalf("'known-hosts' requires a 'list' or 'remove' subcommand.")
+	}
+	switch args[0] {
+	case "list", "l", "ls":
+		if len(args) > 2 {
+			log.Fatalf("'known-hosts list' takes at most one host argument.")
+		}
+		host := "*"
+		if len(args) == 2 {
+			host = args[1]
+		}
+		listKnownHosts(host)
+	case "remove", "rm":
+		if len(args) != 2 {
+			log.Fatalf("'known-hosts remove' requires exactly one host argument.")
+		}
+		removeKnownHost(args[1])
+	default:
+		log.Fatalf("Unknown subcommand for 'known-hosts': %v", args[0])
+	}
+}
+
+// listKnownHosts shows the entries for a host, or all entries of
+// the known hosts files if host is "*"
+func listKnownHosts(host string) {
+	entry, files, err := ssh_config.KnownHostsFor(host)
+	if err != nil {
+		log.Fatalf("Could not resolve known hosts files: %v", err)
+	}
+	if host == "*" {
+		entry = ""
+	}
+	khs, err := ssh_config.FindKnownHosts(files, entry)
+	if err != nil {
+		log.Fatalf("Could not read known hosts: %v", err)
+	}
+	if len(khs) == 0 {
+		log.Infof("No known hosts found in %v.", strings.Join(files, ", "))
+		return
+	}
+
+	tbl := table.New("Hosts", "Type", "Fingerprint", "File")
+	for _, kh := range khs {
+		hosts := strings.Join(kh.Hosts, ",")
+		if kh.Marker != "" {
+			hosts = fmt.Sprintf("@%v %v", kh.Marker, hosts)
+		}
+		tbl.AddRow(hosts, kh.Key.Type(), ssh.FingerprintSHA256(kh.Key),
+			fmt.Sprintf("%v:%d", kh.File, kh.Line))
+	}
+	log.Emitf("%v", tbl)
+}
+
+func removeKnownHost(host string) {
+	entry, files, err := ssh_config.KnownHostsFor(host)
+	if err != nil {
+		log.Fatalf("Could not resolve known hosts files: %v", err)
+	}
+	removed, err := ssh_config.RemoveKnownHost(files, entry)
+	for _, kh := range removed {
+		log.Infof("Removed %v key of %v from %v:%d.", kh.Key.Type(), entry, kh.File, kh.Line)
+	}
+	if err != nil {
+		log.Fatalf("Could not remove known host: %v", err)
+	}
+	if len(removed) == 0 {
+		log.Fatalf("Host %v not found in %v.", entry, strings.Join(files, ", "))
+	}
+}
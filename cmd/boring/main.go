@@ -50,6 +50,13 @@ func main() {
 		controlTunnels(os.Args[2:], daemon.Close)
 	case "list", "l", "ls":
 		listTunnels(os.Args[2:])
+	case "trust", "t":
+		if len(os.Args) != 3 {
+			log.Fatalf("'trust' requires exactly one tunnel name argument.")
+		}
+		trustTunnel(os.Args[2])
+	case "known-hosts", "kh":
+		knownHosts(os.Args[2:])
 	case "edit", "e":
 		editConfig()
 	case "version", "v":
@@ -96,12 +103,19 @@ func printVersion() {
 func printUsage() {
 	log.Printf("The `boring` SSH tunnel manager\n\n")
 	log.Printf("Usage:\n")
-	log.Printf("  boring list, l [-g <group>]    List all tunnels\n")
+	log.Printf(`  boring list, l [options]       List all tunnels
+    -g, --group <group>          List tunnels in a group
+    -s, --stats                  Show traffic and connection statistics` + "\n")
 	log.Printf(`  boring open, o (-a | -g <group> | <patterns>...)
     <patterns>...                Open tunnels matching any glob pattern
     -a, --all                    Open all tunnels
-    -g, --group <group>          Open all tunnels in a group` + "\n")
+    -g, --group <group>          Open all tunnels in a group
+    --for <duration>             Close the tunnels after a time, e.g. '2h'` + "\n")
 	log.Printf("  boring close, c                Close tunnels (same options as 'open')\n")
+	log.Printf("  boring trust, t <name>         Add the host keys of a tunnel to known_hosts\n")
+	log.Printf(`  boring known-hosts, kh         Manage known hosts of the SSH config
+    list [<host>]                List known hosts, or keys of a host
+    remove <host>                Remove the keys of a host` + "\n")
 	log.Printf("  boring edit, e                 Edit the configuration file\n")
 	log.Printf("  boring version, v              Show the version number\n")
 	log.Printf("  boring help, h                 Show this help message\n")
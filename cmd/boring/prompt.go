@@ -0,0 +1,47 @@
+//
+// Answering authentication prompts relayed by the daemon.
+//
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/alebeck/boring/internal/daemon"
+	"golang.org/x/term"
+)
+
+// canAnswer tells whether the user can answer prompts, e.g., passwords
+var canAnswer = term.IsTerminal(int(os.Stdin.Fd()))
+
+var (
+	// promptMu serializes prompts of tunnels opened concurrently
+	promptMu sync.Mutex
+	stdin    = bufio.NewReader(os.Stdin)
+)
+
+// answer asks the user for input on the terminal. Input is hidden,
+// unless the prompt asks for echo.
+func answer(p *daemon.Prompt) (string, error) {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+
+	fmt.Fprint(os.Stderr, p.Text)
+	if !p.Echo {
+		b, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("could not read answer: %v", err)
+		}
+		return string(b), nil
+	}
+	s, err := stdin.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("could not read answer: %v", err)
+	}
+	return strings.TrimRight(s, "\r\n"), nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/alebeck/boring/internal/tunnel"
+)
+
+var statsCols = []string{"Conns", "Total", "Sent", "Recv", "Fails", "Reconns"}
+
+// statsRow renders the statistics columns of a tunnel. Tunnels without
+// statistics, i.e. those not running, get empty columns.
+func statsRow(s *tunnel.Stats) []any {
+	if s == nil {
+		return []any{"", "", "", "", "", ""}
+	}
+	return []any{s.ActiveConns, s.TotalConns, formatBytes(s.BytesSent),
+		formatBytes(s.BytesReceived), s.FailedDials, s.Reconnects}
+}
+
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestFormatBytes(t *testing.T) {
+	cases := map[uint64]string{
+		0:               "0B",
+		1023:            "1023B",
+		1024:            "1.0KiB",
+		1536:            "1.5KiB",
+		5 * 1024 * 1024: "5.0MiB",
+	}
+	for b, want := range cases {
+		if s := formatBytes(b); s != want {
+			t.Errorf("formatBytes(%d) = %s, want %s", b, s, want)
+		}
+	}
+}
+
+func TestStatsRowEmpty(t *testing.T) {
+	for _, c := range statsRow(nil) {
+		if c != "" {
+			t.Fatalf("expected empty columns, got %v", c)
+		}
+	}
+}
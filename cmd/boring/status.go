@@ -14,21 +14,33 @@ func status(t *tunnel.Desc) string {
 		return log.Red + "closed" + log.Reset
 	case tunnel.Reconn:
 		return log.Yellow + "reconn" + log.Reset
+	case tunnel.Idle:
+		return log.Blue + "idle" + log.Reset
+	case tunnel.Failed:
+		return log.Bold + log.Red + "failed" + log.Reset
+	case tunnel.Degraded:
+		return log.Bold + log.Yellow + "degraded" + log.Reset
 	}
 
 	// Tunnel is open, show uptime
-	since := time.Since(t.LastConn)
-	days := int(since / (24 * time.Hour))
-	hours := int(since/time.Hour) % 24
-	mins := int(since/time.Minute) % 60
-	secs := int(since/time.Second) % 60
-	var str string
+	str := log.Bold + log.Green + formatDuration(time.Since(t.LastConn)) + log.Reset
+
+	// Show remaining time for tunnels with a time limit
+	if !t.Deadline.IsZero() {
+		str += "/" + log.Yellow + formatDuration(max(time.Until(t.Deadline), 0)) + log.Reset
+	}
+	return str
+}
+
+func formatDuration(d time.Duration) string {
+	days := int(d / (24 * time.Hour))
+	hours := int(d/time.Hour) % 24
+	mins := int(d/time.Minute) % 60
+	secs := int(d/time.Second) % 60
 	if days > 0 {
-		str = fmt.Sprintf("%02dd%02dh", days, hours)
+		return fmt.Sprintf("%02dd%02dh", days, hours)
 	} else if hours > 0 {
-		str = fmt.Sprintf("%02dh%02dm", hours, mins)
-	} else {
-		str = fmt.Sprintf("%02dm%02ds", mins, secs)
+		return fmt.Sprintf("%02dh%02dm", hours, mins)
 	}
-	return log.Bold + log.Green + str + log.Reset
+	return fmt.Sprintf("%02dm%02ds", mins, secs)
 }
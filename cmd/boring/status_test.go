@@ -23,6 +23,27 @@ func TestStatusReconn(t *testing.T) {
 	}
 }
 
+func TestStatusIdle(t *testing.T) {
+	d := &tunnel.Desc{Status: tunnel.Idle}
+	if s := status(d); s != "idle" {
+		t.Fatalf("incorrect status: %s", s)
+	}
+}
+
+func TestStatusFailed(t *testing.T) {
+	d := &tunnel.Desc{Status: tunnel.Failed}
+	if s := status(d); s != "failed" {
+		t.Fatalf("incorrect status: %s", s)
+	}
+}
+
+func TestStatusDegraded(t *testing.T) {
+	d := &tunnel.Desc{Status: tunnel.Degraded}
+	if s := status(d); s != "degraded" {
+		t.Fatalf("incorrect status: %s", s)
+	}
+}
+
 func TestStatusUptimeMins(t *testing.T) {
 	log.Init(io.Discard, true, false)
 	l := 7*time.Minute + 21*time.Second
@@ -58,3 +79,15 @@ func TestStatusUptimeDays(t *testing.T) {
 		t.Fatalf("incorrect uptime: %s", s)
 	}
 }
+
+func TestStatusDeadline(t *testing.T) {
+	log.Init(io.Discard, true, false)
+	d := &tunnel.Desc{
+		Status:   tunnel.Open,
+		LastConn: time.Now().Add(-7 * time.Minute),
+		Deadline: time.Now().Add(time.Hour + 52*time.Minute + 30*time.Second),
+	}
+	if s := status(d); s != "07m00s/01h52m" {
+		t.Fatalf("incorrect status: %s", s)
+	}
+}
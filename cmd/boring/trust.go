@@ -0,0 +1,53 @@
+//
+// Adding the host keys of a tunnel's hosts to the known hosts.
+//
+
+package main
+
+import (
+	"strings"
+
+	"github.com/alebeck/boring/internal/config"
+	"github.com/alebeck/boring/internal/daemon"
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/tunnel"
+	"golang.org/x/crypto/ssh"
+)
+
+func trustTunnel(name string) {
+	conf, err := config.Load()
+	if err != nil {
+		log.Fatalf("Could not load boring config: %v", err)
+	}
+	t, ok := conf.TunnelsMap[name]
+	if !ok {
+		log.Fatalf("No tunnel named '%v'.", name)
+	}
+
+	if err := tunnel.Trust(t, confirmHostKey, prompt); err != nil {
+		log.Fatalf("Could not trust hosts of '%v': %v", name, err)
+	}
+	log.Infof("All hosts of '%v' are trusted.", name)
+}
+
+// confirmHostKey shows a host key and asks whether to trust it, if unknown
+func confirmHostKey(host string, key ssh.PublicKey, known bool) bool {
+	fp := ssh.FingerprintSHA256(key)
+	if known {
+		log.Infof("Host %v is known, %v key fingerprint is %v.", host, key.Type(), fp)
+		return true
+	}
+	log.Infof("Host %v is unknown, %v key fingerprint is %v.", host, key.Type(), fp)
+	ans, err := answer(&daemon.Prompt{Text: "Are you sure you want to trust it (yes/no)? ", Echo: true})
+	if err != nil {
+		log.Errorf("%v", err)
+		return false
+	}
+	ans = strings.ToLower(strings.TrimSpace(ans))
+	return ans == "yes" || ans == "y"
+}
+
+// prompt answers authentication prompts on the terminal
+func prompt(text string, echo bool) (string, error) {
+	return answer(&daemon.Prompt{Text: text, Echo: echo})
+}
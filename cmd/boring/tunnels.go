@@ -66,6 +66,8 @@ func prepare() (*config.Config, error) {
 func controlTunnels(args []string, kind daemon.CmdKind) {
 	var groupFilter string
 
+	args, ttl := parseTTL(args, kind)
+
 	if args[0] == "--all" || args[0] == "-a" {
 		if len(args) != 1 {
 			log.Fatalf("'--all' does not take any additional arguments.")
@@ -126,12 +128,34 @@ func controlTunnels(args []string, kind daemon.CmdKind) {
 		}
 	}
 
-	// Issue concurrent commands for all tunnels
+	// Issue concurrent commands for all tunnels, opening tunnels after the
+	// ones they depend on, and closing them before
+	type result struct {
+		done chan struct{}
+		err  error // set once done is closed
+	}
+	results := make(map[string]*result, len(keep))
+	for n := range keep {
+		results[n] = &result{done: make(chan struct{})}
+	}
 	var g errgroup.Group
 	for n := range keep {
-		g.Go(func() error {
+		g.Go(func() (err error) {
+			r := results[n]
+			defer func() { r.err = err; close(r.done) }()
+			for _, m := range prerequisites(ts, keep, n, kind) {
+				<-results[m].done
+				if results[m].err != nil && kind == daemon.Open {
+					log.Errorf("Could not open tunnel '%v' since '%v' could not be opened.", n, m)
+					return errOpFailed
+				}
+			}
 			if kind == daemon.Open {
-				return openTunnel(ts[n])
+				t := *ts[n]
+				if ttl > 0 {
+					t.TTL = ttl
+				}
+				return openTunnel(&t)
 			} else if kind == daemon.Close {
 				return closeTunnel(ts[n])
 			}
@@ -145,8 +169,59 @@ func controlTunnels(args []string, kind daemon.CmdKind) {
 	}
 }
 
+// prerequisites returns the names of the tunnels among keep whose command
+// needs to be done before the one of the given tunnel, i.e., the ones it
+// depends on when opening, and the ones depending on it when closing.
+func prerequisites(ts map[string]*tunnel.Desc, keep map[string]bool,
+	name string, kind daemon.CmdKind) []string {
+	var ns []string
+	if kind == daemon.Open {
+		for _, n := range ts[name].Dependencies() {
+			if keep[n] {
+				ns = append(ns, n)
+			}
+		}
+		return ns
+	}
+	for n := range keep {
+		if ts[n].DependsOnTunnel(name) {
+			ns = append(ns, n)
+		}
+	}
+	return ns
+}
+
+// parseTTL extracts the '--for <duration>' option from the arguments
+func parseTTL(args []string, kind daemon.CmdKind) ([]string, tunnel.Duration) {
+	var ttl tunnel.Duration
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		if args[i] != "--for" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if kind != daemon.Open {
+			log.Fatalf("'--for' can only be used with 'open'.")
+		}
+		if i+1 >= len(args) {
+			log.Fatalf("'--for' requires a duration argument, e.g. '2h'.")
+		}
+		i++
+		d, err := time.ParseDuration(args[i])
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid duration '%v' for '--for', use e.g. '2h' or '30m'.", args[i])
+		}
+		ttl = tunnel.Duration(d)
+	}
+	if len(rest) == 0 {
+		log.Fatalf("'open' requires at least one 'pattern' argument," +
+			" or an '--all/-a' or '-g/--group <group>' flag.")
+	}
+	return rest, ttl
+}
+
 func openTunnel(t *tunnel.Desc) error {
-	resp, err := sendCmd(daemon.Cmd{Kind: daemon.Open, Tunnel: *t})
+	resp, err := sendCmd(daemon.Cmd{Kind: daemon.Open, Tunnel: *t, Interactive: canAnswer})
 	if err != nil {
 		log.Errorf("Could not transmit 'open' command: %v", err)
 		return errOpFailed
@@ -161,8 +236,17 @@ func openTunnel(t *tunnel.Desc) error {
 		return errOpFailed
 	}
 
-	log.Infof("Opened tunnel '%s': %s %v %s via %s.", log.Green+log.Bold+t.Name+log.Reset,
-		t.LocalAddress, t.Mode, t.RemoteAddress, t.Host)
+	// Prefer the opened tunnel, e.g., with actually bound addresses
+	if d, ok := resp.Tunnels[t.Name]; ok {
+		t = &d
+	}
+	var fs []string
+	for _, f := range t.AllForwards() {
+		l, r := addresses(f)
+		fs = append(fs, fmt.Sprintf("%v %v %v", l, f.Mode, r))
+	}
+	log.Infof("Opened tunnel '%s': %s via %s.", log.Green+log.Bold+t.Name+log.Reset,
+		strings.Join(fs, ", "), via(t))
 	return nil
 }
 
@@ -199,13 +283,21 @@ func getRunningTunnels() (map[string]*tunnel.Desc, error) {
 
 func listTunnels(args []string) {
 	var groupFilter string
-	if len(args) > 0 && (args[0] == "-g" || args[0] == "--group") {
-		if len(args) != 2 {
-			log.Fatalf("'-g/--group' requires exactly one group name argument.")
+	var showStats bool
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-g", "--group":
+			if i+1 >= len(args) || groupFilter != "" {
+				log.Fatalf("'-g/--group' requires exactly one group name argument.")
+			}
+			i++
+			groupFilter = args[i]
+		case "-s", "--stats":
+			showStats = true
+		default:
+			log.Fatalf("Unknown arguments for 'list'. Use '-g <group>' to filter" +
+				" by group, and '-s' to show statistics.")
 		}
-		groupFilter = args[1]
-	} else if len(args) > 0 {
-		log.Fatalf("Unknown arguments for 'list'. Use '-g <group>' to filter by group.")
 	}
 
 	conf, err := prepare()
@@ -243,7 +335,8 @@ func listTunnels(args []string) {
 		all = filtered
 	}
 
-	printTunnelList(all)
+	printTunnelList(all, showStats)
+	printFailures(all)
 }
 
 // orderTunnelsForList combines configured and running tunnels into an ordered slice.
@@ -273,7 +366,7 @@ func orderTunnelsForList(conf []tunnel.Desc, ts map[string]*tunnel.Desc) []*tunn
 	return all
 }
 
-func printTunnelList(all []*tunnel.Desc) {
+func printTunnelList(all []*tunnel.Desc, showStats bool) {
 	// If any tunnel has a non-empty group, use grouped display
 	hasGroups := false
 	for _, t := range all {
@@ -283,7 +376,7 @@ func printTunnelList(all []*tunnel.Desc) {
 		}
 	}
 	if !hasGroups {
-		log.Emitf("%v", tunnelTable(all))
+		log.Emitf("%v", tunnelTable(all, showStats))
 		return
 	}
 
@@ -311,18 +404,88 @@ func printTunnelList(all []*tunnel.Desc) {
 			header = "default"
 		}
 		log.Emitf("%s[%s]%s\n", log.Bold+log.Blue, header, log.Reset)
-		log.Emitf("%v", tunnelTable(groups[gk]))
+		log.Emitf("%v", tunnelTable(groups[gk], showStats))
+	}
+}
+
+// printFailures shows why tunnels failed or are degraded, and warns
+// about certificates which are about to expire
+func printFailures(all []*tunnel.Desc) {
+	for _, t := range all {
+		if !t.CertExpiry.IsZero() && t.Status != tunnel.Closed && t.Status != tunnel.Failed {
+			if left := time.Until(t.CertExpiry); left <= 0 {
+				log.Warningf("Tunnel '%v': certificate expired at %v.", t.Name,
+					t.CertExpiry.Format(time.DateTime))
+			} else if left < t.CertRenewIn() {
+				log.Warningf("Tunnel '%v': certificate expires in %v.", t.Name,
+					left.Round(time.Second))
+			}
+		}
+		if t.LastError == "" {
+			continue
+		}
+		switch t.Status {
+		case tunnel.Failed:
+			log.Errorf("Tunnel '%v' failed: %v", t.Name, t.LastError)
+		case tunnel.Degraded:
+			log.Warningf("Tunnel '%v' is degraded: %v", t.Name, t.LastError)
+		}
 	}
 }
 
-func tunnelTable(tunnels []*tunnel.Desc) *table.Table {
-	tbl := table.New("Status", "Name", "Local", "", "Remote", "Via")
+func tunnelTable(tunnels []*tunnel.Desc, showStats bool) *table.Table {
+	cols := []string{"Status", "Name", "Local", "", "Remote", "Via"}
+	if showStats {
+		cols = append(cols, statsCols...)
+	}
+	tbl := table.New(cols...)
 	for _, t := range tunnels {
-		tbl.AddRow(status(t), t.Name, t.LocalAddress, t.Mode, t.RemoteAddress, t.Host)
+		fs := t.AllForwards()
+		if len(fs) == 0 {
+			fs = []tunnel.Forward{t.Forward}
+		}
+		l, r := addresses(fs[0])
+		row := []any{status(t), t.Name, l, fs[0].Mode, r, via(t)}
+		if showStats {
+			row = append(row, statsRow(t.Stats)...)
+		}
+		tbl.AddRow(row...)
+		// Additional forwards are listed below their tunnel
+		for _, f := range fs[1:] {
+			l, r := addresses(f)
+			row := []any{"", "", l, f.Mode, r, ""}
+			if showStats {
+				row = append(row, statsRow(nil)...)
+			}
+			tbl.AddRow(row...)
+		}
 	}
 	return tbl
 }
 
+// addresses returns the local and remote address of a forward to show,
+// using the actually bound address for ephemeral ports
+func addresses(f tunnel.Forward) (string, string) {
+	l, r := f.LocalAddress.String(), f.RemoteAddress.String()
+	if f.Bound != "" && f.Ephemeral() {
+		if f.Mode == tunnel.Remote || f.Mode == tunnel.RemoteSocks {
+			r = f.Bound
+		} else {
+			l = f.Bound
+		}
+	}
+	return l, r
+}
+
+// via returns the host a tunnel is connected through, or all of
+// its hosts if it is not connected
+func via(t *tunnel.Desc) string {
+	if t.ActiveHost != "" {
+		return t.ActiveHost
+	}
+	return strings.Join(t.AllHosts(), ",")
+}
+
 func filterByPatterns(ts map[string]*tunnel.Desc, pats []string) (map[string]bool, []string) {
 	keep := make(map[string]bool, len(ts))
 	var notMatched []string
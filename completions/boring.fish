@@ -6,8 +6,10 @@ function __boring_get_names
     # retrieve names based on status
     if test "$stat" = "closed"
         set names (boring list 2>/dev/null | awk '$1 == "closed" { print $2 }')
+    else if test "$stat" = "all"
+        set names (boring list 2>/dev/null | awk '$1 != "Status" && NF >= 4 { print $2 }')
     else
-        set names (boring list 2>/dev/null | awk '$1 != "closed" && $1 != "Status" && NF >= 2 { print $2 }')
+        set names (boring list 2>/dev/null | awk '$1 != "closed" && $1 != "Status" && NF >= 4 { print $2 }')
     end
 
     # filter names based on already provided arguments
@@ -34,7 +36,7 @@ function __boring_complete
     set arguments (commandline -opc)[3..-1]
 
     if test (count $command) -eq 0
-        printf "%s\n" open close list edit version help
+        printf "%s\n" open close list trust known-hosts edit version help
         return
     end
 
@@ -53,6 +55,10 @@ function __boring_complete
             __boring_get_names closed $arguments
         case close c
             __boring_get_names open $arguments
+        case trust t
+            if test (count $arguments) -eq 0
+                __boring_get_names all
+            end
     end
 end
 
@@ -2,52 +2,136 @@ package agent
 
 import (
 	"fmt"
+	"io"
 	"net"
-	"os"
+	"strings"
 	"sync"
 
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/paths"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
 )
 
+type client struct {
+	agent.ExtendedAgent
+	conn net.Conn
+}
+
 var (
-	// Keep a single agent instance for all connection attempts
-	inst agent.ExtendedAgent
-	mu   sync.Mutex
+	// Keep a single connection per agent socket for all connection attempts
+	clients = make(map[string]*client)
+	mu      sync.Mutex
 )
 
-func getAgent() (agent.ExtendedAgent, error) {
+func getAgent(sock string) (*client, error) {
 	mu.Lock()
 	defer mu.Unlock()
 
-	if inst != nil {
-		return inst, nil
+	if c, ok := clients[sock]; ok {
+		return c, nil
 	}
 
-	sock := os.Getenv("SSH_AUTH_SOCK")
 	if sock == "" {
 		return nil, fmt.Errorf("SSH_AUTH_SOCK is not set")
 	}
 
-	conn, err := net.Dial("unix", sock)
+	conn, err := net.Dial("unix", paths.ReplaceTilde(sock))
 	if err != nil {
 		return nil, fmt.Errorf("could not dial agent: %v", err)
 	}
 
-	inst = agent.NewClient(conn)
-	return inst, nil
+	c := &client{ExtendedAgent: agent.NewClient(conn), conn: conn}
+	clients[sock] = c
+	return c, nil
 }
 
-func GetSigners() ([]ssh.Signer, error) {
-	agent, err := getAgent()
-	if err != nil {
-		return nil, err
+// drop discards the connection to an agent, such that the next call
+// re-connects, e.g., after the agent was restarted
+func drop(sock string, c *client) {
+	mu.Lock()
+	defer mu.Unlock()
+	if clients[sock] == c {
+		delete(clients, sock)
+		c.conn.Close()
+	}
+}
+
+// withAgent calls fn with the agent listening on sock. If the connection
+// to the agent broke, e.g., since it was restarted, fn is retried once on
+// a new connection. Requests refused by the agent are not retried, since
+// agents confirming each use of a key would ask again.
+func withAgent[T any](sock string, fn func(agent.ExtendedAgent) (T, error)) (res T, err error) {
+	for i := 0; i < 2; i++ {
+		var c *client
+		if c, err = getAgent(sock); err != nil {
+			return
+		}
+		if res, err = fn(c); err == nil || !connErr(err) {
+			return
+		}
+		log.Debugf("agent %v: %v, re-connecting", sock, err)
+		drop(sock, c)
 	}
+	return
+}
 
-	signers, err := agent.Signers()
+// connErr tells whether an error of the agent client is due to the
+// connection rather than the agent. The client doesn't wrap the errors
+// of the connection, so they are only told apart by their message.
+func connErr(err error) bool {
+	return strings.HasPrefix(err.Error(), "agent: client error")
+}
+
+// GetSigners returns the keys held by the agent listening on sock. The
+// signers re-connect to the agent if needed, so they remain usable after
+// the agent was restarted.
+func GetSigners(sock string) ([]ssh.Signer, error) {
+	keys, err := withAgent(sock, func(a agent.ExtendedAgent) ([]*agent.Key, error) {
+		return a.List()
+	})
 	if err != nil {
 		return nil, fmt.Errorf("could not retrieve signers from agent: %v", err)
 	}
 
+	signers := make([]ssh.Signer, 0, len(keys))
+	for _, k := range keys {
+		pub, err := ssh.ParsePublicKey(k.Blob)
+		if err != nil {
+			log.Debugf("agent %v: skipping key %v: %v", sock, k.Comment, err)
+			continue
+		}
+		signers = append(signers, &signer{sock: sock, pub: pub})
+	}
 	return signers, nil
 }
+
+// signer signs via the agent listening on sock
+type signer struct {
+	sock string
+	pub  ssh.PublicKey
+}
+
+func (s *signer) PublicKey() ssh.PublicKey {
+	return s.pub
+}
+
+func (s *signer) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.SignWithAlgorithm(rand, data, "")
+}
+
+func (s *signer) SignWithAlgorithm(_ io.Reader, data []byte, algo string) (*ssh.Signature, error) {
+	var flags agent.SignatureFlags
+	if strings.HasPrefix(algo, ssh.KeyAlgoRSASHA256) {
+		flags = agent.SignatureFlagRsaSha256
+	} else if strings.HasPrefix(algo, ssh.KeyAlgoRSASHA512) {
+		flags = agent.SignatureFlagRsaSha512
+	}
+	return withAgent(s.sock, func(a agent.ExtendedAgent) (*ssh.Signature, error) {
+		return a.SignWithFlags(s.pub, data, flags)
+	})
+}
+
+func (s *signer) String() string {
+	return fmt.Sprintf("%v %v (agent %v)", s.pub.Type(), ssh.FingerprintSHA256(s.pub), s.sock)
+}
@@ -0,0 +1,139 @@
+package agent
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/alebeck/boring/internal/log"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func init() {
+	log.Init(io.Discard, false, false)
+}
+
+// serve serves kr on sock until the returned function is called, which
+// also closes all connections, like a terminating agent
+func serve(t *testing.T, sock string, kr agent.Agent) func() {
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var conns []net.Conn
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, c)
+			mu.Unlock()
+			go agent.ServeAgent(kr, c)
+		}
+	}()
+	return func() {
+		ln.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, c := range conns {
+			c.Close()
+		}
+		os.Remove(sock)
+	}
+}
+
+func TestAgentRestart(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr := agent.NewKeyring()
+	if err := kr.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+
+	stop := serve(t, sock, kr)
+	sigs, err := GetSigners(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sigs) != 1 {
+		t.Fatalf("expected one signer, got %d", len(sigs))
+	}
+
+	// Signers and later calls use the restarted agent
+	stop()
+	defer serve(t, sock, kr)()
+	sig, err := sigs[0].Sign(rand.Reader, []byte("data"))
+	if err != nil {
+		t.Fatalf("could not sign after restart: %v", err)
+	}
+	if err := sigs[0].PublicKey().Verify([]byte("data"), sig); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetSigners(sock); err != nil {
+		t.Fatalf("could not get signers after restart: %v", err)
+	}
+}
+
+// refusing is an agent refusing to sign, like one whose user denied the
+// confirmation of a key
+type refusing struct {
+	agent.Agent
+	mu    sync.Mutex
+	signs int
+}
+
+func (r *refusing) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.signs++
+	return nil, errors.New("denied")
+}
+
+func TestAgentRefusalNotRetried(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr := agent.NewKeyring()
+	if err := kr.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatal(err)
+	}
+	r := &refusing{Agent: kr}
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	defer serve(t, sock, r)()
+
+	sigs, err := GetSigners(sock)
+	if err != nil || len(sigs) != 1 {
+		t.Fatalf("expected one signer, got %v: %v", sigs, err)
+	}
+	if _, err := sigs[0].Sign(rand.Reader, []byte("data")); err == nil {
+		t.Fatal("expected refused signature")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.signs != 1 {
+		t.Errorf("agent asked to sign %d times, want once", r.signs)
+	}
+}
+
+func TestAgentMissing(t *testing.T) {
+	if _, err := GetSigners(filepath.Join(t.TempDir(), "missing.sock")); err == nil {
+		t.Error("expected error for missing agent")
+	}
+	if _, err := GetSigners(""); err == nil {
+		t.Error("expected error for empty socket")
+	}
+}
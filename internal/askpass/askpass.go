@@ -0,0 +1,68 @@
+package askpass
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Program returns the askpass program given by $BORING_ASKPASS, falling
+// back to $SSH_ASKPASS. It is empty if neither is set.
+func Program() string {
+	if p := os.Getenv("BORING_ASKPASS"); p != "" {
+		return p
+	}
+	return os.Getenv("SSH_ASKPASS")
+}
+
+// Available tells whether prompts can be answered via an askpass program
+func Available() bool {
+	return Program() != ""
+}
+
+// Ask runs the askpass program with the given prompt and returns its
+// answer. Like ssh(1), $SSH_ASKPASS_PROMPT is left unset for prompts
+// expecting input. If the answer may be echoed, e.g., for questions the
+// server marks as such, $BORING_ASKPASS_ECHO is set to yes, such that the
+// program can show the input.
+func Ask(prompt string, echo bool) (string, error) {
+	if echo {
+		return run(prompt, "BORING_ASKPASS_ECHO=yes")
+	}
+	return run(prompt)
+}
+
+// Confirm asks a yes/no question via the askpass program. Like ssh(1), it
+// sets $SSH_ASKPASS_PROMPT to confirm, and takes a successful exit with
+// an empty answer or "yes" as confirmation.
+func Confirm(prompt string) (bool, error) {
+	out, err := run(prompt, "SSH_ASKPASS_PROMPT=confirm")
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return false, nil
+		}
+		return false, err
+	}
+	out = strings.TrimSpace(out)
+	return out == "" || strings.EqualFold(out, "yes"), nil
+}
+
+// run runs the askpass program with the given prompt and additional
+// environment variables, and returns its output
+func run(prompt string, env ...string) (string, error) {
+	prog := Program()
+	if prog == "" {
+		return "", fmt.Errorf("no askpass program set")
+	}
+
+	cmd := exec.Command(prog, prompt)
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("askpass program %v failed: %w", prog, err)
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
@@ -0,0 +1,63 @@
+package askpass
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestAsk(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	prog := filepath.Join(t.TempDir(), "askpass")
+	script := "#!/bin/sh\necho \"answer to $1 ${SSH_ASKPASS_PROMPT:-secret} ${BORING_ASKPASS_ECHO:-hidden}\"\n"
+	if err := os.WriteFile(prog, []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SSH_ASKPASS", "")
+	t.Setenv("BORING_ASKPASS", prog)
+	t.Setenv("BORING_ASKPASS_ECHO", "")
+
+	if !Available() {
+		t.Fatal("askpass not available")
+	}
+	if a, err := Ask("Password:", false); err != nil || a != "answer to Password: secret hidden" {
+		t.Errorf("incorrect answer %q: %v", a, err)
+	}
+	if a, err := Ask("Code:", true); err != nil || a != "answer to Code: secret yes" {
+		t.Errorf("incorrect answer %q: %v", a, err)
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	prog := filepath.Join(t.TempDir(), "askpass")
+	script := "#!/bin/sh\n[ \"$SSH_ASKPASS_PROMPT\" = confirm ] || exit 2\n" +
+		"case \"$1\" in yes*) echo yes ;; empty*) ;; *) exit 1 ;; esac\n"
+	if err := os.WriteFile(prog, []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SSH_ASKPASS", "")
+	t.Setenv("BORING_ASKPASS", prog)
+
+	for prompt, want := range map[string]bool{"yes?": true, "empty?": true, "no?": false} {
+		if ok, err := Confirm(prompt); err != nil || ok != want {
+			t.Errorf("Confirm(%q) = %v, %v, want %v", prompt, ok, err, want)
+		}
+	}
+}
+
+func TestAskUnset(t *testing.T) {
+	t.Setenv("SSH_ASKPASS", "")
+	t.Setenv("BORING_ASKPASS", "")
+	if Available() {
+		t.Error("askpass should not be available")
+	}
+	if _, err := Ask("Password:", false); err == nil {
+		t.Error("expected error")
+	}
+}
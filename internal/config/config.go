@@ -2,9 +2,11 @@ package config
 
 import (
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 
 	"github.com/BurntSushi/toml"
@@ -17,19 +19,41 @@ const (
 	socksLabel = "[SOCKS]"
 )
 
-var defaultKeepAliveInterval = 2 * 60 // seconds
-
 var Path string
 
 // Config represents the application configuration as parsed from ./boring.toml
 type Config struct {
 	// Tunnels is a list of tunnel descriptions
 	Tunnels []tunnel.Desc `toml:"tunnels"`
+	// Hosts is a list of host definitions, which tunnels can refer
+	// to instead of hosts in ssh_config
+	Hosts []tunnel.HostDef `toml:"hosts"`
 	// KeepAlive allows to specify a global keep alive interval,
-	// (in seconds) overriding the default one. `0` indicates
-	// no keep alive.
-	KeepAlive  *int                    `toml:"keep_alive"`
-	TunnelsMap map[string]*tunnel.Desc `toml:"-"`
+	// (in seconds) overriding ssh_config and the default one.
+	// `0` indicates no keep alive.
+	KeepAlive *int `toml:"keep_alive"`
+	// KeepAliveCountMax, ConnectTimeout and ConnectionAttempts
+	// allow to override the respective ssh_config options globally.
+	KeepAliveCountMax  *int            `toml:"keep_alive_count_max"`
+	ConnectTimeout     *tunnel.Timeout `toml:"connect_timeout"`
+	ConnectionAttempts *int            `toml:"connection_attempts"`
+	// IdentityAgent allows to specify the agents used by all tunnels,
+	// overriding ssh_config.
+	IdentityAgent tunnel.StringOrList `toml:"identity_agent"`
+	// Proxy allows to specify an upstream proxy for all tunnels,
+	// overriding $ALL_PROXY.
+	Proxy string `toml:"proxy"`
+	// CertRenewCommand and CertRenewBefore allow to specify how
+	// certificates of all tunnels are renewed.
+	CertRenewCommand string           `toml:"cert_renew_command"`
+	CertRenewBefore  *tunnel.Duration `toml:"cert_renew_before"`
+	// Reconnect allows to specify a global re-connection policy,
+	// fields set on tunnel level take precedence.
+	Reconnect tunnel.Reconnect `toml:"reconnect"`
+	// PassphraseCache allows to specify for how long the daemon keeps
+	// decrypted keys, overriding the default of forever.
+	PassphraseCache *tunnel.Duration        `toml:"passphrase_cache"`
+	TunnelsMap      map[string]*tunnel.Desc `toml:"-"`
 }
 
 func init() {
@@ -54,19 +78,48 @@ func getConfigHome() string {
 
 // Load parses the boring configuration file
 func Load() (*Config, error) {
-	cfg := Config{KeepAlive: &defaultKeepAliveInterval}
+	var cfg Config
 
 	if _, err := toml.DecodeFile(Path, &cfg); err != nil {
 		return nil, fmt.Errorf("could not decode config file: %w", err)
 	}
 
-	// Set global keep alive interval for all tunnels
-	// that don't specify one on their own.
+	// Set global options for all tunnels that don't
+	// specify them on their own.
 	for i := range cfg.Tunnels {
 		t := &cfg.Tunnels[i]
 		if t.KeepAlive == nil {
 			t.KeepAlive = cfg.KeepAlive
 		}
+		if t.KeepAliveCountMax == nil {
+			t.KeepAliveCountMax = cfg.KeepAliveCountMax
+		}
+		if t.ConnectTimeout == nil {
+			t.ConnectTimeout = cfg.ConnectTimeout
+		}
+		if t.ConnectionAttempts == nil {
+			t.ConnectionAttempts = cfg.ConnectionAttempts
+		}
+		if t.IdentityAgent == nil {
+			t.IdentityAgent = cfg.IdentityAgent
+		}
+		if t.Proxy == "" {
+			t.Proxy = cfg.Proxy
+		}
+		if t.CertRenewCommand == "" {
+			t.CertRenewCommand = cfg.CertRenewCommand
+		}
+		if t.CertRenewBefore == nil {
+			t.CertRenewBefore = cfg.CertRenewBefore
+		}
+		if t.PassphraseCache == nil {
+			t.PassphraseCache = cfg.PassphraseCache
+		}
+		t.Reconnect.Merge(cfg.Reconnect)
+		if err := t.Reconnect.Validate(); err != nil {
+			return nil, fmt.Errorf("tunnel '%v': invalid reconnect policy: %w",
+				t.Name, err)
+		}
 	}
 
 	// Create a map of tunnel names to tunnel pointers for easy lookup later
@@ -75,21 +128,104 @@ func Load() (*Config, error) {
 		return nil, err
 	}
 
-	// Replace the remote address of Socks tunnels and local address of reverse
-	// socks tunnels by a fixed indicator, it is not used for anything anyway
+	// Attach the definitions of all hosts a tunnel may connect through
+	hosts, err := buildHostsMap(cfg.Hosts)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range m {
+		for _, h := range t.AllHosts() {
+			if _, ok := hosts[h]; !ok {
+				// Resolved via ssh_config
+				continue
+			}
+			chain, err := hosts.Chain(h)
+			if err != nil {
+				return nil, err
+			}
+			if t.HostDefs == nil {
+				t.HostDefs = make(tunnel.HostDefs)
+			}
+			for _, n := range chain {
+				t.HostDefs[n] = hosts[n]
+			}
+		}
+	}
+
+	// Replace the remote address of Socks forwards and local address of reverse
+	// socks forwards by a fixed indicator, it is not used for anything anyway
 	for _, t := range m {
-		switch t.Mode {
-		case tunnel.Socks:
-			t.RemoteAddress = socksLabel
-		case tunnel.RemoteSocks:
-			t.LocalAddress = socksLabel
+		if t.LocalAddress != "" || t.RemoteAddress != "" {
+			labelSocks(&t.Forward)
+		}
+		for i := range t.Forwards {
+			labelSocks(&t.Forwards[i])
 		}
 	}
 
+	if err := resolveDeps(m); err != nil {
+		return nil, err
+	}
+
 	cfg.TunnelsMap = m
 	return &cfg, nil
 }
 
+// resolveDeps attaches the tunnels that tunnels depend on or connect
+// through, such that the daemon can open them first. Cycles are rejected.
+func resolveDeps(m map[string]*tunnel.Desc) error {
+	for _, t := range m {
+		if t.ViaTunnel != "" {
+			v, ok := m[t.ViaTunnel]
+			if !ok {
+				return fmt.Errorf("tunnel '%v': via_tunnel '%v' is not defined",
+					t.Name, t.ViaTunnel)
+			}
+			t.Via = v
+		}
+		if err := t.ValidateVia(); err != nil {
+			return fmt.Errorf("tunnel '%v': %v", t.Name, err)
+		}
+		for _, n := range t.DependsOn {
+			dep, ok := m[n]
+			if !ok {
+				return fmt.Errorf("tunnel '%v': depends_on '%v' is not defined",
+					t.Name, n)
+			}
+			t.Deps = append(t.Deps, dep)
+		}
+	}
+
+	// Depth-first search, reporting the first cycle found
+	const visiting, visited = 1, 2
+	state := make(map[string]int, len(m))
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		path = append(path, name)
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("tunnel dependencies form a cycle: %v",
+				strings.Join(path[slices.Index(path, name):], " -> "))
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, n := range m[name].Dependencies() {
+			if err := visit(n, path); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	for _, n := range slices.Sorted(maps.Keys(m)) {
+		if err := visit(n, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func buildTunnelsMap(tunnels []tunnel.Desc) (map[string]*tunnel.Desc, error) {
 	m := make(map[string]*tunnel.Desc)
 	for i := range tunnels {
@@ -114,6 +250,33 @@ func buildTunnelsMap(tunnels []tunnel.Desc) (map[string]*tunnel.Desc, error) {
 	return m, nil
 }
 
+func buildHostsMap(hosts []tunnel.HostDef) (tunnel.HostDefs, error) {
+	m := make(tunnel.HostDefs)
+	for _, h := range hosts {
+		if _, exists := m[h.Name]; exists {
+			return nil, fmt.Errorf("found duplicated host name '%v'", h.Name)
+		}
+		if h.Name == "" || strings.ContainsAny(h.Name, " \t") || containsGlob(h.Name) {
+			return nil, fmt.Errorf("host names cannot be empty, contain spaces,"+
+				" or contain glob characters '*?['. Found '%v'.", h.Name)
+		}
+		m[h.Name] = h
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func labelSocks(f *tunnel.Forward) {
+	switch f.Mode {
+	case tunnel.Socks:
+		f.RemoteAddress = socksLabel
+	case tunnel.RemoteSocks:
+		f.LocalAddress = socksLabel
+	}
+}
+
 func specialPrefix(s string) bool {
 	if s == "" {
 		return false
@@ -1,8 +1,13 @@
 package config
 
 import (
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/alebeck/boring/internal/tunnel"
 )
 
 func TestLoadMissingFile(t *testing.T) {
@@ -19,3 +24,288 @@ func TestSpecialPrefixEmpty(t *testing.T) {
 		t.Error(`specialPrefix("") = true, want false`)
 	}
 }
+
+func TestLoadReconnect(t *testing.T) {
+	orig := Path
+	t.Cleanup(func() { Path = orig })
+	Path = filepath.Join(t.TempDir(), "config.toml")
+	conf := `
+[reconnect]
+max_attempts = 5
+max_duration = "forever"
+
+[[tunnels]]
+name = "a"
+local = "1234"
+remote = "localhost:1234"
+host = "a"
+
+[tunnels.reconnect]
+max_attempts = 2
+`
+	if err := os.WriteFile(Path, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := cfg.TunnelsMap["a"].Reconnect
+	if r.MaxAttempts == nil || *r.MaxAttempts != 2 {
+		t.Errorf("incorrect max attempts: %v", r.MaxAttempts)
+	}
+	if r.MaxDuration == nil || *r.MaxDuration != tunnel.Forever {
+		t.Errorf("incorrect max duration: %v", r.MaxDuration)
+	}
+}
+
+func TestLoadPassphraseCache(t *testing.T) {
+	orig := Path
+	t.Cleanup(func() { Path = orig })
+	Path = filepath.Join(t.TempDir(), "config.toml")
+	conf := `
+passphrase_cache = "1h"
+
+[[tunnels]]
+name = "a"
+local = "1234"
+remote = "localhost:1234"
+host = "a"
+
+[[tunnels]]
+name = "b"
+local = "1235"
+remote = "localhost:1235"
+host = "b"
+passphrase_cache = "0s"
+`
+	if err := os.WriteFile(Path, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := cfg.TunnelsMap["a"].PassphraseCache; c == nil || *c != tunnel.Duration(time.Hour) {
+		t.Errorf("incorrect passphrase cache: %v", c)
+	}
+	if c := cfg.TunnelsMap["b"].PassphraseCache; c == nil || *c != 0 {
+		t.Errorf("incorrect passphrase cache: %v", c)
+	}
+}
+
+func TestLoadConnectionOptions(t *testing.T) {
+	orig := Path
+	t.Cleanup(func() { Path = orig })
+	Path = filepath.Join(t.TempDir(), "config.toml")
+	conf := `
+keep_alive_count_max = 5
+connect_timeout = "20s"
+proxy = "socks5://proxy:1080"
+
+[[tunnels]]
+name = "a"
+local = "1234"
+remote = "localhost:1234"
+host = "a"
+
+[[tunnels]]
+name = "b"
+local = "1235"
+remote = "localhost:1235"
+host = "b"
+keep_alive = 10
+keep_alive_count_max = 1
+connection_attempts = 3
+proxy = "none"
+`
+	if err := os.WriteFile(Path, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := cfg.TunnelsMap["a"], cfg.TunnelsMap["b"]
+	// Unset keep-alives fall back to ssh_config
+	if a.KeepAlive != nil || a.ConnectionAttempts != nil {
+		t.Errorf("expected unset options: %v, %v", a.KeepAlive, a.ConnectionAttempts)
+	}
+	if a.KeepAliveCountMax == nil || *a.KeepAliveCountMax != 5 {
+		t.Errorf("incorrect keep-alive count: %v", a.KeepAliveCountMax)
+	}
+	if a.ConnectTimeout == nil || *a.ConnectTimeout != tunnel.Timeout(20*time.Second) {
+		t.Errorf("incorrect connect timeout: %v", a.ConnectTimeout)
+	}
+	if b.KeepAlive == nil || *b.KeepAlive != 10 ||
+		b.KeepAliveCountMax == nil || *b.KeepAliveCountMax != 1 {
+		t.Errorf("incorrect keep-alive options: %v, %v", b.KeepAlive, b.KeepAliveCountMax)
+	}
+	if b.ConnectionAttempts == nil || *b.ConnectionAttempts != 3 {
+		t.Errorf("incorrect connection attempts: %v", b.ConnectionAttempts)
+	}
+	if a.Proxy != "socks5://proxy:1080" || b.Proxy != "none" {
+		t.Errorf("incorrect proxies: %v, %v", a.Proxy, b.Proxy)
+	}
+}
+
+func TestLoadHosts(t *testing.T) {
+	orig := Path
+	t.Cleanup(func() { Path = orig })
+	Path = filepath.Join(t.TempDir(), "config.toml")
+	conf := `
+[[hosts]]
+name = "bastion"
+hostname = "bastion.example.com"
+
+[[hosts]]
+name = "db"
+hostname = "10.0.0.2"
+jump = ["bastion"]
+
+[[hosts]]
+name = "unused"
+
+[[tunnels]]
+name = "a"
+local = "1234"
+remote = "localhost:1234"
+host = "db"
+
+[[tunnels]]
+name = "b"
+local = "1235"
+remote = "localhost:1235"
+host = "other"
+`
+	if err := os.WriteFile(Path, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := cfg.TunnelsMap["a"]
+	if len(a.HostDefs) != 2 || a.HostDefs["db"].HostName != "10.0.0.2" ||
+		a.HostDefs["bastion"].HostName != "bastion.example.com" {
+		t.Errorf("incorrect host definitions: %v", a.HostDefs)
+	}
+	if b := cfg.TunnelsMap["b"]; b.HostDefs != nil {
+		t.Errorf("expected no host definitions: %v", b.HostDefs)
+	}
+}
+
+func TestLoadHostsCycle(t *testing.T) {
+	orig := Path
+	t.Cleanup(func() { Path = orig })
+	Path = filepath.Join(t.TempDir(), "config.toml")
+	conf := `
+[[hosts]]
+name = "a"
+jump = ["b"]
+
+[[hosts]]
+name = "b"
+jump = ["a"]
+`
+	if err := os.WriteFile(Path, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("expected cycle error, got %v", err)
+	}
+}
+
+func TestLoadVia(t *testing.T) {
+	orig := Path
+	t.Cleanup(func() { Path = orig })
+	dir := t.TempDir()
+	Path = filepath.Join(dir, "config.toml")
+	conf := `
+[[tunnels]]
+name = "a"
+local = "1234"
+remote = "localhost:1234"
+host = "a"
+via_tunnel = "b"
+
+[[tunnels]]
+name = "b"
+local = "1235"
+mode = "socks"
+host = "b"
+`
+	if err := os.WriteFile(Path, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := cfg.TunnelsMap["a"].Via; v == nil || v.Name != "b" {
+		t.Errorf("incorrect via tunnel: %v", v)
+	}
+
+	for _, c := range []struct{ conf, err string }{
+		{"[[tunnels]]\nname = \"a\"\nvia_tunnel = \"c\"\n", "not defined"},
+		{"[[tunnels]]\nname = \"a\"\nvia_tunnel = \"a\"\n", "cycle"},
+		{"[[tunnels]]\nname = \"a\"\nvia_tunnel = \"b\"\n" +
+			"[[tunnels]]\nname = \"b\"\nvia_tunnel = \"a\"\n", "cycle"},
+		{"[[tunnels]]\nname = \"a\"\nvia_mode = \"socks\"\n", "requires via_tunnel"},
+		{"[[tunnels]]\nname = \"a\"\nvia_tunnel = \"b\"\nvia_mode = \"vpn\"\n" +
+			"[[tunnels]]\nname = \"b\"\n", "unsupported via_mode"},
+		{"[[tunnels]]\nname = \"a\"\nvia_tunnel = \"b\"\nvia_mode = \"forward\"\n" +
+			"[[tunnels]]\nname = \"b\"\nlocal = \"1235\"\nmode = \"socks\"\n", "local forward"},
+	} {
+		if err := os.WriteFile(Path, []byte(c.conf), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("expected error containing '%v', got %v", c.err, err)
+		}
+	}
+}
+
+func TestLoadDependsOn(t *testing.T) {
+	orig := Path
+	t.Cleanup(func() { Path = orig })
+	Path = filepath.Join(t.TempDir(), "config.toml")
+	conf := `
+[[tunnels]]
+name = "a"
+depends_on = ["b", "c"]
+
+[[tunnels]]
+name = "b"
+via_tunnel = "c"
+
+[[tunnels]]
+name = "c"
+`
+	if err := os.WriteFile(Path, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := cfg.TunnelsMap["a"]
+	if len(a.Deps) != 2 || a.Deps[0].Name != "b" || a.Deps[1].Name != "c" {
+		t.Errorf("incorrect dependencies: %v", a.Deps)
+	}
+
+	for _, c := range []struct{ conf, err string }{
+		{"[[tunnels]]\nname = \"a\"\ndepends_on = [\"b\"]\n", "not defined"},
+		{"[[tunnels]]\nname = \"a\"\ndepends_on = [\"a\"]\n", "cycle: a -> a"},
+		{"[[tunnels]]\nname = \"a\"\ndepends_on = [\"b\"]\n" +
+			"[[tunnels]]\nname = \"b\"\nvia_tunnel = \"c\"\n" +
+			"[[tunnels]]\nname = \"c\"\ndepends_on = [\"b\"]\n", "cycle: b -> c -> b"},
+	} {
+		if err := os.WriteFile(Path, []byte(c.conf), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("expected error containing '%v', got %v", c.err, err)
+		}
+	}
+}
@@ -14,6 +14,8 @@ const (
 	Close
 	List
 	Shutdown
+	// Answer answers a prompt sent by the daemon while handling a command
+	Answer
 )
 
 var cmdKindNames = map[CmdKind]string{
@@ -22,6 +24,7 @@ var cmdKindNames = map[CmdKind]string{
 	Close:    "Close",
 	List:     "List",
 	Shutdown: "Shutdown",
+	Answer:   "Answer",
 }
 
 func (k CmdKind) String() string {
@@ -36,4 +39,8 @@ func (k CmdKind) String() string {
 type Cmd struct {
 	Kind   CmdKind     `json:"kind"`
 	Tunnel tunnel.Desc `json:"tunnel,omitempty"`
+	// Interactive indicates that the sender can answer prompts
+	Interactive bool `json:"interactive,omitempty"`
+	// Answer is the answer to a prompt, for Answer commands
+	Answer string `json:"answer,omitempty"`
 }
@@ -17,6 +17,7 @@ import (
 	"github.com/alebeck/boring/internal/buildinfo"
 	"github.com/alebeck/boring/internal/ipc"
 	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/ssh_config"
 	"github.com/alebeck/boring/internal/tunnel"
 )
 
@@ -49,6 +50,9 @@ type daemon struct {
 	// TODO: write proper concurrent map structure for this
 	tunnels map[string]*tunnel.Tunnel
 	mutex   sync.RWMutex
+	// opening serializes opening tunnels of the same name, e.g., when a
+	// tunnel is opened on its own and as the via tunnel of another one
+	opening map[string]*sync.Mutex
 
 	once sync.Once
 	wg   sync.WaitGroup
@@ -57,7 +61,8 @@ type daemon struct {
 func newDaemon(parent context.Context, ln net.Listener) (*daemon, context.CancelFunc) {
 	ctx, cancel := context.WithCancel(parent)
 	tunnels := make(map[string]*tunnel.Tunnel)
-	d := &daemon{ctx: ctx, cancel: cancel, ln: ln, tunnels: tunnels}
+	opening := make(map[string]*sync.Mutex)
+	d := &daemon{ctx: ctx, cancel: cancel, ln: ln, tunnels: tunnels, opening: opening}
 
 	go func() {
 		// Parent-driven shutdown
@@ -132,7 +137,7 @@ func (d *daemon) handleConn(conn net.Conn) {
 	case Nop:
 		respond(conn, nil, nil)
 	case Open:
-		d.openTunnel(conn, &cmd.Tunnel)
+		d.openTunnel(conn, &cmd)
 	case Close:
 		d.closeTunnel(conn, &cmd.Tunnel)
 	case List:
@@ -147,24 +152,71 @@ func (d *daemon) handleConn(conn net.Conn) {
 	}
 }
 
-func (d *daemon) openTunnel(conn net.Conn, desc *tunnel.Desc) {
+func (d *daemon) openTunnel(conn net.Conn, cmd *Cmd) {
 	var err error
-	defer func() { respond(conn, err, nil) }()
+	var ts map[string]tunnel.Desc
+	defer func() { respond(conn, err, ts) }()
+
+	var p ssh_config.Prompter
+	if cmd.Interactive {
+		p = prompter(conn)
+	}
+	t, err := d.open(&cmd.Tunnel, p)
+	if err != nil {
+		log.Errorf("%v: could not open: %v", cmd.Tunnel.Name, err)
+		return
+	}
+	// Report back the opened tunnel, e.g., the host in use
+	ts = map[string]tunnel.Desc{t.Name: t.Snapshot()}
+}
+
+// open opens the tunnel described by desc. Tunnels it depends on or connects
+// through which are not running are opened first. If the tunnel is running
+// already, it is returned along with AlreadyRunning.
+func (d *daemon) open(desc *tunnel.Desc, p ssh_config.Prompter) (*tunnel.Tunnel, error) {
+	unlock := d.lockOpening(desc.Name)
+	defer unlock()
 
 	d.mutex.RLock()
-	_, exists := d.tunnels[desc.Name]
+	prev, exists := d.tunnels[desc.Name]
 	d.mutex.RUnlock()
-	if exists {
-		err = AlreadyRunning
-		log.Errorf("%v: could not open: %v", desc.Name, err)
-		return
+	// Failed tunnels are only kept for display and can be re-opened
+	if exists && prev.State() != tunnel.Failed {
+		return prev, AlreadyRunning
+	}
+
+	var via *tunnel.Tunnel
+	if desc.Via != nil {
+		var err error
+		via, err = d.open(desc.Via, p)
+		if err == nil {
+			log.Infof("%v: opened tunnel %v to connect through", desc.Name, via.Name)
+		} else if !errors.Is(err, AlreadyRunning) {
+			return nil, fmt.Errorf("could not open tunnel %v: %v", desc.ViaTunnel, err)
+		}
+	}
+	for _, dep := range desc.Deps {
+		_, err := d.open(dep, p)
+		if err == nil {
+			log.Infof("%v: opened tunnel %v it depends on", desc.Name, dep.Name)
+		} else if !errors.Is(err, AlreadyRunning) {
+			return nil, fmt.Errorf("could not open tunnel %v: %v", dep.Name, err)
+		}
 	}
 
 	t := tunnel.FromDesc(desc)
-	if err = t.Open(); err != nil {
-		log.Errorf("%v: could not open: %v", t.Name, err)
-		return
+	t.SetVia(via)
+	t.SetOnReconnect(func() { d.reconnectDependents(t.Name) })
+	t.SetPrompter(p)
+	err := t.Open()
+	t.SetPrompter(nil)
+	if err != nil {
+		return nil, err
 	}
+	if t.TTL > 0 {
+		t.Deadline = time.Now().Add(time.Duration(t.TTL))
+	}
+	go d.watchTimeouts(t)
 
 	d.mutex.Lock()
 	d.tunnels[t.Name] = t
@@ -173,11 +225,140 @@ func (d *daemon) openTunnel(conn net.Conn, desc *tunnel.Desc) {
 	// Register closing logic
 	go func() {
 		<-t.Closed
+		// Tunnels connecting through this one cannot do without it
+		d.closeDependents(t.Name)
+		if t.State() == tunnel.Failed {
+			log.Infof("Tunnel %s failed", t.Name)
+			return
+		}
 		d.mutex.Lock()
-		delete(d.tunnels, t.Name)
+		if d.tunnels[t.Name] == t {
+			delete(d.tunnels, t.Name)
+		}
 		d.mutex.Unlock()
 		log.Infof("Closed tunnel %s", t.Name)
 	}()
+	return t, nil
+}
+
+// lockOpening locks opening the tunnel of the given name and returns
+// the function unlocking it
+func (d *daemon) lockOpening(name string) func() {
+	d.mutex.Lock()
+	mu, ok := d.opening[name]
+	if !ok {
+		mu = &sync.Mutex{}
+		d.opening[name] = mu
+	}
+	d.mutex.Unlock()
+	mu.Lock()
+	return mu.Unlock
+}
+
+// dependents returns the running tunnels which depend on or connect
+// through the tunnel of the given name
+func (d *daemon) dependents(name string) []*tunnel.Tunnel {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	var deps []*tunnel.Tunnel
+	for _, t := range d.tunnels {
+		if s := t.State(); t.DependsOnTunnel(name) && s != tunnel.Failed && s != tunnel.Closed {
+			deps = append(deps, t)
+		}
+	}
+	return deps
+}
+
+// closeDependents closes all running tunnels depending on the tunnel of
+// the given name, including the ones depending on those, and waits for
+// them to close.
+func (d *daemon) closeDependents(name string) {
+	for _, t := range d.dependents(name) {
+		d.closeDependents(t.Name)
+		log.Infof("%v: closing since tunnel %v closes", t.Name, name)
+		if err := t.Close(); err != nil && !errors.Is(err, tunnel.ErrClosing) {
+			log.Errorf("%v: could not close tunnel: %v", t.Name, err)
+			continue
+		}
+		<-t.Closed
+	}
+}
+
+// reconnectDependents re-connects the running tunnels depending on the
+// tunnel of the given name, e.g., since they use its forwards. Tunnels
+// connecting through it re-connect on their own.
+func (d *daemon) reconnectDependents(name string) {
+	for _, t := range d.dependents(name) {
+		if t.ViaTunnel == name {
+			continue
+		}
+		log.Infof("%v: tunnel %v it depends on re-connected", t.Name, name)
+		t.ForceReconnect()
+	}
+}
+
+// watchTimeouts closes a tunnel once its deadline is reached, or once it
+// had no active connections for longer than its idle timeout.
+func (d *daemon) watchTimeouts(t *tunnel.Tunnel) {
+	if t.TTL <= 0 && t.IdleTimeout <= 0 {
+		return
+	}
+
+	var deadline <-chan time.Time
+	if !t.Deadline.IsZero() {
+		dl := time.NewTimer(time.Until(t.Deadline))
+		defer dl.Stop()
+		deadline = dl.C
+	}
+
+	var idleCheck <-chan time.Time
+	idleTimeout := time.Duration(t.IdleTimeout)
+	idle := time.NewTimer(idleTimeout)
+	defer idle.Stop()
+	if idleTimeout > 0 {
+		idleCheck = idle.C
+	}
+
+	for {
+		select {
+		case <-t.Closed:
+			return
+		case <-deadline:
+			log.Infof("%v: time limit of %v reached", t.Name, t.TTL)
+		case <-idleCheck:
+			since := t.IdleFor()
+			if since < idleTimeout {
+				idle.Reset(idleTimeout - since)
+				continue
+			}
+			log.Infof("%v: idle for %v", t.Name, idleTimeout)
+		}
+		if err := t.Close(); err != nil {
+			log.Errorf("%v: could not close tunnel: %v", t.Name, err)
+		}
+		return
+	}
+}
+
+// prompter relays authentication prompts to the interactive sender
+// of a command over conn
+func prompter(conn net.Conn) func(string, bool) (string, error) {
+	var mu sync.Mutex
+	return func(text string, echo bool) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := ipc.Write(Resp{Prompt: &Prompt{Text: text, Echo: echo}}, conn); err != nil {
+			return "", fmt.Errorf("could not send prompt: %v", err)
+		}
+		var cmd Cmd
+		if err := ipc.Read(&cmd, conn); err != nil {
+			return "", fmt.Errorf("could not receive answer: %v", err)
+		}
+		if cmd.Kind != Answer {
+			return "", fmt.Errorf("expected answer, got %v", cmd.Kind)
+		}
+		return cmd.Answer, nil
+	}
 }
 
 func (d *daemon) closeTunnel(conn net.Conn, q *tunnel.Desc) {
@@ -193,10 +374,24 @@ func (d *daemon) closeTunnel(conn net.Conn, q *tunnel.Desc) {
 		return
 	}
 
-	if err = t.Close(); err != nil {
+	if t.State() == tunnel.Failed {
+		d.mutex.Lock()
+		if d.tunnels[t.Name] == t {
+			delete(d.tunnels, t.Name)
+		}
+		d.mutex.Unlock()
+		log.Infof("Removed failed tunnel %s", t.Name)
+		return
+	}
+
+	d.closeDependents(t.Name)
+	// The tunnel may be closing already, e.g., since it depended on
+	// another one being closed
+	if err = t.Close(); err != nil && !errors.Is(err, tunnel.ErrClosing) {
 		log.Errorf("%v: could not close tunnel: %v", t.Name, err)
 		return
 	}
+	err = nil
 	<-t.Closed
 }
 
@@ -204,7 +399,9 @@ func (d *daemon) listTunnels(conn net.Conn) {
 	d.mutex.RLock()
 	ts := make(map[string]tunnel.Desc, len(d.tunnels))
 	for n, t := range d.tunnels {
-		ts[n] = *t.Desc
+		desc := t.Snapshot()
+		desc.Stats = t.CollectStats()
+		ts[n] = desc
 	}
 	d.mutex.RUnlock()
 	respond(conn, nil, ts)
@@ -10,10 +10,20 @@ type Info struct {
 	Commit string `json:"commit"`
 }
 
-// Resp represents a response from the daemon
+// Prompt asks an interactive sender of a command for input, e.g.,
+// a password. The sender replies with an Answer command.
+type Prompt struct {
+	Text string `json:"text"`
+	// Echo indicates that the input does not need to be hidden
+	Echo bool `json:"echo"`
+}
+
+// Resp represents a response from the daemon. Responses carrying a
+// Prompt precede the final response.
 type Resp struct {
 	Success bool                   `json:"success"`
 	Error   string                 `json:"error,omitempty"`
 	Tunnels map[string]tunnel.Desc `json:"tunnels,omitempty"`
 	Info    Info                   `json:"info,omitempty"`
+	Prompt  *Prompt                `json:"prompt,omitempty"`
 }
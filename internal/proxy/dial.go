@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	xproxy "golang.org/x/net/proxy"
+)
+
+// ParseURL parses the URL of an upstream proxy, which is either a SOCKS5
+// proxy like socks5://host:port or an HTTP proxy like http://host:port,
+// supporting CONNECT requests. Both may contain credentials.
+func ParseURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %v", err)
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h", "http", "https":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme '%v'", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("proxy URL has no host")
+	}
+	return u, nil
+}
+
+// NewDialer returns a dialer connecting through the proxy at u. Connecting
+// to the proxy, and for HTTP proxies the CONNECT request, are subject to
+// timeout, if positive.
+func NewDialer(u *url.URL, timeout time.Duration) (xproxy.Dialer, error) {
+	forward := &net.Dialer{Timeout: timeout}
+	if u.Scheme == "http" || u.Scheme == "https" {
+		return &httpDialer{proxy: u, forward: forward, timeout: timeout}, nil
+	}
+	return xproxy.FromURL(u, forward)
+}
+
+// FromEnvironment returns a dialer connecting through the proxy given by
+// $ALL_PROXY, except for hosts matching $NO_PROXY, or nil if it is not set.
+func FromEnvironment(timeout time.Duration) (xproxy.Dialer, error) {
+	s := getEnv("ALL_PROXY")
+	if s == "" {
+		return nil, nil
+	}
+	u, err := ParseURL(s)
+	if err != nil {
+		return nil, fmt.Errorf("ALL_PROXY: %v", err)
+	}
+	d, err := NewDialer(u, timeout)
+	if err != nil {
+		return nil, err
+	}
+	noProxy := getEnv("NO_PROXY")
+	if noProxy == "" {
+		return d, nil
+	}
+	perHost := xproxy.NewPerHost(d, &net.Dialer{Timeout: timeout})
+	perHost.AddFromString(noProxy)
+	return perHost, nil
+}
+
+// EnvironmentKey identifies the proxy given by $ALL_PROXY, without
+// credentials, along with the hosts excluded by $NO_PROXY. It returns ""
+// if $ALL_PROXY is not set or invalid.
+func EnvironmentKey() string {
+	s := getEnv("ALL_PROXY")
+	if s == "" {
+		return ""
+	}
+	u, err := ParseURL(s)
+	if err != nil {
+		return ""
+	}
+	if noProxy := getEnv("NO_PROXY"); noProxy != "" {
+		return u.Redacted() + "!" + noProxy
+	}
+	return u.Redacted()
+}
+
+func getEnv(key string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return os.Getenv(strings.ToLower(key))
+}
+
+// httpDialer connects through an HTTP proxy via CONNECT requests
+type httpDialer struct {
+	proxy   *url.URL
+	forward *net.Dialer
+	timeout time.Duration
+}
+
+func (h *httpDialer) Dial(network, addr string) (net.Conn, error) {
+	host := h.proxy.Host
+	if h.proxy.Port() == "" {
+		port := "80"
+		if h.proxy.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(h.proxy.Hostname(), port)
+	}
+	conn, err := h.forward.Dial(network, host)
+	if err != nil {
+		return nil, err
+	}
+	if h.proxy.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: h.proxy.Hostname()})
+	}
+	if h.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(h.timeout))
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u := h.proxy.User; u != nil {
+		pass, _ := u.Password()
+		cred := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+cred)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not send CONNECT request: %v", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not read CONNECT response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused to connect: %v", resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+
+	// The server may have spoken first, e.g., sending its SSH version
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn reads from r first, holding data read past the response
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,296 @@
+package ssh_config
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/paths"
+	ossh_config "github.com/alebeck/ssh_config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	hostKeysRequest      = "hostkeys-00@openssh.com"
+	hostKeysProveRequest = "hostkeys-prove-00@openssh.com"
+)
+
+// hostKeyUpdate updates the known hosts once a server announces its host
+// keys, like ssh(1) with UpdateHostKeys. Announced keys which are not yet
+// known must be proven by the server, known keys which are not announced
+// anymore are removed.
+type hostKeyUpdate struct {
+	alias string
+	// entry is the normalized known hosts entry of the host
+	entry string
+	file  string
+	hash  bool
+	// ask, if set, asks to confirm updates
+	ask Prompter
+}
+
+// defaultUserKnownHostsFile returns the file new host keys are added to
+// if UserKnownHostsFile is not configured
+func defaultUserKnownHostsFile() string {
+	files := strings.Fields(ossh_config.Default("UserKnownHostsFile"))
+	if len(files) == 0 {
+		return ""
+	}
+	return files[0]
+}
+
+// hostKeyUpdate returns how to update the host keys of sc, or nil if
+// they are not updated
+func (sc *SSHConfig) hostKeyUpdate() *hostKeyUpdate {
+	if sc.UpdateHostKeys != "yes" && sc.UpdateHostKeys != "ask" {
+		return nil
+	}
+	// Like ssh(1), keys are only updated by default if the default known
+	// hosts file is in use
+	if !sc.updateHostKeysSet && paths.ReplaceTilde(sc.UserKnownHostsFile) !=
+		paths.ReplaceTilde(defaultUserKnownHostsFile()) {
+		return nil
+	}
+	// Only update keys verified against the known hosts
+	if sc.KeyCheck == off || sc.hopPins() != nil || sc.UserKnownHostsFile == "" {
+		return nil
+	}
+	u := &hostKeyUpdate{
+		alias: sc.Alias,
+		entry: knownhosts.Normalize(sc.HostName + ":" + strconv.Itoa(sc.Port)),
+		file:  paths.ReplaceTilde(sc.UserKnownHostsFile),
+		hash:  sc.HashKnownHosts,
+	}
+	if sc.UpdateHostKeys == "ask" {
+		if sc.Prompt == nil {
+			return nil
+		}
+		u.ask = sc.Prompt
+	}
+	return u
+}
+
+// HandleRequests handles host key announcements on a client connection to
+// the hop, and passes on all other global requests
+func (h Hop) HandleRequests(c ssh.Conn, reqs <-chan *ssh.Request) <-chan *ssh.Request {
+	if h.hostKeys == nil {
+		return reqs
+	}
+	out := make(chan *ssh.Request)
+	go func() {
+		defer close(out)
+		for r := range reqs {
+			if r.Type != hostKeysRequest {
+				out <- r
+				continue
+			}
+			if r.WantReply {
+				r.Reply(false, nil)
+			}
+			// Proving keys requires another request, so don't block here
+			go func(payload []byte) {
+				if err := h.hostKeys.update(c, payload); err != nil {
+					log.Warningf("%v: could not update host keys: %v", h.hostKeys.alias, err)
+				}
+			}(r.Payload)
+		}
+	}()
+	return out
+}
+
+func (u *hostKeyUpdate) update(c ssh.Conn, payload []byte) error {
+	announced, err := parseHostKeys(payload)
+	if err != nil {
+		return err
+	}
+
+	// Keys of entries listing other hosts as well are kept
+	var known, removable []ssh.PublicKey
+	khs, err := FindKnownHosts([]string{u.file}, u.entry)
+	if err != nil {
+		return err
+	}
+	for _, kh := range khs {
+		if kh.Marker != "" {
+			continue
+		}
+		known = append(known, kh.Key)
+		if len(kh.Hosts) == 1 {
+			removable = append(removable, kh.Key)
+		}
+	}
+	// Hosts which are only known via other files or authorities are left alone
+	if !anyKey(announced, known) {
+		log.Debugf("%v: host %v has no keys in %v, not updating", u.alias, u.entry, u.file)
+		return nil
+	}
+
+	var add, remove []ssh.PublicKey
+	for _, k := range announced {
+		if !containsKey(known, k) {
+			add = append(add, k)
+		}
+	}
+	for _, k := range removable {
+		if !containsKey(announced, k) {
+			remove = append(remove, k)
+		}
+	}
+	if len(add) == 0 && len(remove) == 0 {
+		log.Debugf("%v: host keys of %v are up to date", u.alias, u.entry)
+		return nil
+	}
+
+	if len(add) > 0 {
+		if err := proveHostKeys(c, add); err != nil {
+			return err
+		}
+	}
+
+	if u.ask != nil {
+		msg := fmt.Sprintf("%v announced %d new and %d deprecated host key(s).\n"+
+			"Update %v (yes/no)? ", u.entry, len(add), len(remove), u.file)
+		ans, err := u.ask(msg, true)
+		if err != nil {
+			return err
+		}
+		if a := strings.ToLower(strings.TrimSpace(ans)); a != "yes" && a != "y" {
+			return nil
+		}
+	}
+
+	if err := updateKnownHost(u.file, u.entry, add, remove, u.hash); err != nil {
+		return err
+	}
+	for _, k := range add {
+		log.Infof("%v: added %v host key %v of %v to %v", u.alias, k.Type(),
+			ssh.FingerprintSHA256(k), u.entry, u.file)
+	}
+	for _, k := range remove {
+		log.Infof("%v: removed deprecated %v host key %v of %v from %v", u.alias, k.Type(),
+			ssh.FingerprintSHA256(k), u.entry, u.file)
+	}
+	return nil
+}
+
+// parseHostKeys parses the keys of a host key announcement, skipping
+// unsupported ones
+func parseHostKeys(payload []byte) (keys []ssh.PublicKey, err error) {
+	for len(payload) > 0 {
+		var blob []byte
+		if blob, payload, err = parseString(payload); err != nil {
+			return nil, err
+		}
+		k, err := ssh.ParsePublicKey(blob)
+		if err != nil {
+			log.Debugf("skipping announced host key: %v", err)
+			continue
+		}
+		if _, ok := k.(*ssh.Certificate); ok {
+			return nil, errors.New("server announced a certificate as host key")
+		}
+		if containsKey(keys, k) {
+			return nil, fmt.Errorf("server announced duplicate %v host key", k.Type())
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("server announced no supported host keys")
+	}
+	return keys, nil
+}
+
+// proveHostKeys asks the server to prove possession of the private keys
+// belonging to keys, by signing the session identifier
+func proveHostKeys(c ssh.Conn, keys []ssh.PublicKey) error {
+	var req []byte
+	for _, k := range keys {
+		req = append(req, ssh.Marshal(struct{ Key []byte }{k.Marshal()})...)
+	}
+	ok, resp, err := c.SendRequest(hostKeysProveRequest, true, req)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("server refused to prove host keys")
+	}
+
+	for _, k := range keys {
+		var blob []byte
+		if blob, resp, err = parseString(resp); err != nil {
+			return fmt.Errorf("invalid host key proof: %v", err)
+		}
+		var sig ssh.Signature
+		if err := ssh.Unmarshal(blob, &sig); err != nil {
+			return fmt.Errorf("invalid host key proof: %v", err)
+		}
+		data := ssh.Marshal(struct {
+			Type      string
+			SessionID []byte
+			Key       []byte
+		}{hostKeysProveRequest, c.SessionID(), k.Marshal()})
+		if err := k.Verify(data, &sig); err != nil {
+			return fmt.Errorf("server could not prove %v host key %v: %v",
+				k.Type(), ssh.FingerprintSHA256(k), err)
+		}
+	}
+	if len(resp) > 0 {
+		return errors.New("invalid host key proof: trailing data")
+	}
+	return nil
+}
+
+// updateKnownHost adds and removes keys of a host in a known hosts file.
+// Only entries which consist of the host alone are removed.
+func updateKnownHost(file, entry string, add, remove []ssh.PublicKey, hash bool) error {
+	if len(remove) > 0 {
+		knownHostsMu.Lock()
+		var keep bytes.Buffer
+		err := scanKnownHosts(file, func(line string, kh *KnownHost) {
+			if kh != nil && kh.Marker == "" && len(kh.Hosts) == 1 &&
+				matchHosts(kh, entry) && containsKey(remove, kh.Key) {
+				return
+			}
+			keep.WriteString(line + "\n")
+		})
+		if err == nil {
+			err = replaceFile(file, keep.Bytes())
+		}
+		knownHostsMu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	for _, k := range add {
+		if err := addKnownHost(file, entry, k, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func anyKey(keys, in []ssh.PublicKey) bool {
+	for _, k := range keys {
+		if containsKey(in, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseString parses an SSH wire format string
+func parseString(in []byte) (s, rest []byte, err error) {
+	if len(in) < 4 {
+		return nil, nil, errors.New("short read")
+	}
+	n := binary.BigEndian.Uint32(in)
+	if uint32(len(in)-4) < n {
+		return nil, nil, errors.New("short read")
+	}
+	return in[4 : 4+n], in[4+n:], nil
+}
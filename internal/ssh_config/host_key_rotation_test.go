@@ -0,0 +1,102 @@
+package ssh_config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseHostKeys(t *testing.T) {
+	k1, k2 := edPub(t), edPub(t)
+	var payload []byte
+	for _, k := range []ssh.PublicKey{k1, k2} {
+		payload = append(payload, ssh.Marshal(struct{ Key []byte }{k.Marshal()})...)
+	}
+	// An unsupported key type is skipped
+	payload = append(payload, ssh.Marshal(struct{ Key []byte }{
+		ssh.Marshal(struct{ Type string }{"ssh-unknown"})})...)
+
+	keys, err := parseHostKeys(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || !containsKey(keys, k1) || !containsKey(keys, k2) {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+
+	dup := ssh.Marshal(struct{ A, B []byte }{k1.Marshal(), k1.Marshal()})
+	if _, err := parseHostKeys(dup); err == nil {
+		t.Error("expected error for duplicate keys")
+	}
+	if _, err := parseHostKeys(payload[:len(payload)-1]); err == nil {
+		t.Error("expected error for truncated payload")
+	}
+}
+
+func TestUpdateKnownHost(t *testing.T) {
+	oldKey, keptKey, newKey := edPub(t), edPub(t), edPub(t)
+	p := filepath.Join(t.TempDir(), "known_hosts")
+	lines := "[127.0.0.1]:2222 " + string(ssh.MarshalAuthorizedKey(oldKey)) +
+		"[127.0.0.1]:2222,other " + string(ssh.MarshalAuthorizedKey(keptKey))
+	if err := os.WriteFile(p, []byte(lines), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	remove := []ssh.PublicKey{oldKey, keptKey}
+	add := []ssh.PublicKey{newKey}
+	if err := updateKnownHost(p, "[127.0.0.1]:2222", add, remove, false); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(b)
+	if strings.Contains(s, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(oldKey)))) {
+		t.Errorf("old key not removed: %s", s)
+	}
+	// Entries listing other hosts are kept
+	if !strings.Contains(s, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(keptKey)))) {
+		t.Errorf("shared entry removed: %s", s)
+	}
+	if !strings.Contains(s, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(newKey)))) {
+		t.Errorf("new key not added: %s", s)
+	}
+}
+
+func TestHostKeyUpdateDefault(t *testing.T) {
+	orig := overrideConfig
+	t.Cleanup(func() { overrideConfig = orig })
+	overrideConfig = filepath.Join(t.TempDir(), "config")
+	conf := `Host explicit
+    UpdateHostKeys yes
+Host *
+    UserKnownHostsFile /tmp/known_hosts_custom
+`
+	if err := os.WriteFile(overrideConfig, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	sc, err := ParseSSHConfig("explicit", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.hostKeyUpdate() == nil {
+		t.Error("explicit UpdateHostKeys ignored")
+	}
+	sc, err = ParseSSHConfig("implicit", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.UpdateHostKeys != "yes" || sc.hostKeyUpdate() != nil {
+		t.Errorf("default UpdateHostKeys applied with custom UserKnownHostsFile")
+	}
+	sc.UserKnownHostsFile = "~/.ssh/known_hosts"
+	if sc.hostKeyUpdate() == nil {
+		t.Error("default UpdateHostKeys not applied with default UserKnownHostsFile")
+	}
+}
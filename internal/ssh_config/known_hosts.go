@@ -1,9 +1,22 @@
 package ssh_config
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"io"
 	"net"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/alebeck/boring/internal/paths"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/knownhosts"
@@ -69,3 +82,256 @@ func extractHostKeyAlgos(cb ssh.HostKeyCallback, host string) (res []string) {
 	}
 	return
 }
+
+// knownHostsMu serializes writes to known hosts files
+var knownHostsMu sync.Mutex
+
+// addKnownHost appends a host key to a known hosts file, creating it if
+// needed. Like ssh(1) with HashKnownHosts, the host name can be hashed.
+func addKnownHost(file, host string, key ssh.PublicKey, hash bool) error {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	file = paths.ReplaceTilde(file)
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entry := knownhosts.Normalize(host)
+	if hash {
+		entry = knownhosts.HashHostname(entry)
+	}
+	line := knownhosts.Line([]string{entry}, key) + "\n"
+
+	// Make sure the new entry starts on its own line
+	if fi, err := f.Stat(); err == nil && fi.Size() > 0 {
+		b := make([]byte, 1)
+		if _, err := f.ReadAt(b, fi.Size()-1); err != nil && err != io.EOF {
+			return err
+		}
+		if b[0] != '\n' {
+			line = "\n" + line
+		}
+	}
+	if _, err := f.WriteString(line); err != nil {
+		return fmt.Errorf("could not write to %v: %v", file, err)
+	}
+	return nil
+}
+
+// explainKeyErrors wraps a known hosts callback, see explainKeyError
+func explainKeyErrors(cb ssh.HostKeyCallback) ssh.HostKeyCallback {
+	return func(host string, remote net.Addr, key ssh.PublicKey) error {
+		return explainKeyError(host, key, cb(host, remote, key))
+	}
+}
+
+// explainKeyError turns errors of knownhosts callbacks into explanations
+// like the ones of ssh(1), pointing to the conflicting known hosts entries.
+func explainKeyError(host string, key ssh.PublicKey, err error) error {
+	var ke *knownhosts.KeyError
+	var re *knownhosts.RevokedError
+	entry := knownhosts.Normalize(host)
+	fp := ssh.FingerprintSHA256(key)
+
+	switch {
+	case errors.As(err, &re):
+		return fmt.Errorf("%s host key for %v (%v) is marked as revoked in %v:%d",
+			key.Type(), entry, fp, re.Revoked.Filename, re.Revoked.Line)
+	case errors.As(err, &ke) && len(ke.Want) > 0:
+		var b strings.Builder
+		fmt.Fprintf(&b, "REMOTE HOST IDENTIFICATION HAS CHANGED! "+
+			"Someone could be eavesdropping on you right now (man-in-the-middle attack), "+
+			"or the host key has just been changed.\n")
+		fmt.Fprintf(&b, "The %s host key sent by %v has fingerprint %v.\n", key.Type(), entry, fp)
+		for _, w := range ke.Want {
+			fmt.Fprintf(&b, "Offending %s key in %v:%d with fingerprint %v.\n",
+				w.Key.Type(), w.Filename, w.Line, ssh.FingerprintSHA256(w.Key))
+		}
+		fmt.Fprintf(&b, "If the change is expected, remove the old key via "+
+			"`boring known-hosts remove %v`.", entry)
+		return errors.New(b.String())
+	case errors.As(err, &ke):
+		return fmt.Errorf("%s host key for %v (%v) is not known. Add it via "+
+			"`boring trust <tunnel>`", key.Type(), entry, fp)
+	}
+	return err
+}
+
+// KnownHost is an entry of a known hosts file
+type KnownHost struct {
+	File   string
+	Line   int
+	Marker string
+	// Hosts holds the host patterns of the entry, hashed ones are
+	// replaced by the host they matched
+	Hosts []string
+	Key   ssh.PublicKey
+}
+
+// KnownHostsFor resolves a host alias, optionally with a port, to its
+// known hosts entry like "[host]:port", and to the known hosts files
+// configured for it.
+func KnownHostsFor(host string) (entry string, files []string, err error) {
+	alias, port := host, ""
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		alias, port = h, p
+	}
+	sc, err := ParseSSHConfig(alias, "")
+	if err != nil {
+		return "", nil, err
+	}
+	if sc.HostName == "" {
+		sc.HostName = alias
+	}
+	if port == "" {
+		port = strconv.Itoa(sc.Port)
+	}
+	for _, f := range sc.KnownHostsFiles {
+		files = append(files, paths.ReplaceTilde(f))
+	}
+	return knownhosts.Normalize(net.JoinHostPort(sc.HostName, port)), files, nil
+}
+
+// FindKnownHosts returns the entries of the given files matching a
+// normalized host entry, or all entries if it is empty. Missing files
+// are skipped.
+func FindKnownHosts(files []string, entry string) ([]KnownHost, error) {
+	var res []KnownHost
+	for _, f := range files {
+		err := scanKnownHosts(f, func(_ string, kh *KnownHost) {
+			if kh != nil && (entry == "" || matchHosts(kh, entry)) {
+				res = append(res, *kh)
+			}
+		})
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// RemoveKnownHost removes all entries matching a normalized host entry
+// from the given files, and returns them. Like ssh-keygen -R, entries with
+// markers are kept, and the original files are kept with suffix ".old".
+func RemoveKnownHost(files []string, entry string) ([]KnownHost, error) {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	var removed []KnownHost
+	for _, f := range files {
+		var keep bytes.Buffer
+		var rm []KnownHost
+		err := scanKnownHosts(f, func(line string, kh *KnownHost) {
+			if kh != nil && kh.Marker == "" && matchHosts(kh, entry) {
+				rm = append(rm, *kh)
+				return
+			}
+			keep.WriteString(line + "\n")
+		})
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		if len(rm) == 0 {
+			continue
+		}
+		if err := replaceFile(f, keep.Bytes()); err != nil {
+			return removed, err
+		}
+		removed = append(removed, rm...)
+	}
+	return removed, nil
+}
+
+// scanKnownHosts calls fn for each line of a known hosts file, with the
+// parsed entry or nil for comments and invalid lines
+func scanKnownHosts(file string, fn func(line string, kh *KnownHost)) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		var kh *KnownHost
+		if t := strings.TrimSpace(line); t != "" && !strings.HasPrefix(t, "#") {
+			marker, hosts, key, _, _, err := ssh.ParseKnownHosts([]byte(t))
+			if err == nil {
+				kh = &KnownHost{File: file, Line: n, Marker: marker, Hosts: hosts, Key: key}
+			}
+		}
+		fn(line, kh)
+	}
+	return sc.Err()
+}
+
+// matchHosts tells whether one of the host patterns of an entry is the
+// given normalized host entry, either literally or hashed. Hashed patterns
+// which match are replaced by the entry.
+func matchHosts(kh *KnownHost, entry string) bool {
+	for i, h := range kh.Hosts {
+		if h == entry || hashMatches(h, entry) {
+			kh.Hosts[i] = entry
+			return true
+		}
+	}
+	return false
+}
+
+// hashMatches checks a hashed host pattern of the form |1|salt|hash
+func hashMatches(pattern, entry string) bool {
+	parts := strings.Split(pattern, "|")
+	if len(parts) != 4 || parts[0] != "" || parts[1] != "1" {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(entry))
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+// replaceFile atomically replaces a file's content, keeping a backup
+func replaceFile(file string, content []byte) error {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), fi.Mode().Perm()); err != nil {
+		return err
+	}
+	os.Remove(file + ".old")
+	if err := os.Rename(file, file+".old"); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
@@ -5,9 +5,11 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"io"
+	"net"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/alebeck/boring/internal/log"
@@ -126,3 +128,164 @@ func TestMakeCallbackAndAlgos(t *testing.T) {
 		t.Fatalf("not narrowed to pinned type: got %v, want %v", algs, want)
 	}
 }
+
+func TestAddKnownHost(t *testing.T) {
+	for _, hash := range []bool{false, true} {
+		file := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+		k := edPub(t)
+		if err := addKnownHost(file, testHostPort, k, hash); err != nil {
+			t.Fatal(err)
+		}
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hashed := raw[0] == '|'; hashed != hash {
+			t.Errorf("expected hashed=%v, got %s", hash, raw)
+		}
+		cb, err := knownhosts.New(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cb(testHostPort, &net.TCPAddr{}, k); err != nil {
+			t.Errorf("added key not accepted: %v", err)
+		}
+	}
+}
+
+func TestAddKnownHostNewline(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	other := knownhosts.Line([]string{"other"}, edPub(t))
+	if err := os.WriteFile(file, []byte(other), 0600); err != nil {
+		t.Fatal(err)
+	}
+	k := edPub(t)
+	if err := addKnownHost(file, testHostPort, k, false); err != nil {
+		t.Fatal(err)
+	}
+	cb, err := knownhosts.New(file)
+	if err != nil {
+		t.Fatalf("file was corrupted: %v", err)
+	}
+	if err := cb(testHostPort, &net.TCPAddr{}, k); err != nil {
+		t.Errorf("added key not accepted: %v", err)
+	}
+}
+
+func TestAcceptNew(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	sc := &SSHConfig{
+		KeyCheck:           acceptNew,
+		KnownHostsFiles:    []string{file},
+		UserKnownHostsFile: file,
+	}
+	cb := sc.acceptNewCallback()
+	k := edPub(t)
+	if err := cb(testHostPort, &net.TCPAddr{}, k); err != nil {
+		t.Fatalf("new key not accepted: %v", err)
+	}
+	if err := cb(testHostPort, &net.TCPAddr{}, k); err != nil {
+		t.Fatalf("known key not accepted: %v", err)
+	}
+	if err := cb(testHostPort, &net.TCPAddr{}, edPub(t)); err == nil {
+		t.Fatal("changed key accepted")
+	}
+}
+
+func TestTrustRejected(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	var seen []bool
+	sc := &SSHConfig{
+		KnownHostsFiles:    []string{file},
+		UserKnownHostsFile: file,
+		Trust: func(_ string, _ ssh.PublicKey, known bool) bool {
+			seen = append(seen, known)
+			return false
+		},
+	}
+	if err := sc.acceptNewCallback()(testHostPort, &net.TCPAddr{}, edPub(t)); err == nil {
+		t.Fatal("untrusted key accepted")
+	}
+	if _, err := os.Stat(file); err == nil {
+		t.Error("untrusted key was added")
+	}
+	if !reflect.DeepEqual(seen, []bool{false}) {
+		t.Errorf("unexpected trust calls: %v", seen)
+	}
+}
+
+func TestExplainKeyError(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	k := edPub(t)
+	if err := addKnownHost(file, testHostPort, k, false); err != nil {
+		t.Fatal(err)
+	}
+	cb, err := knownhosts.New(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := edPub(t)
+	err = explainKeyErrors(cb)(testHostPort, &net.TCPAddr{}, other)
+	if err == nil {
+		t.Fatal("changed key accepted")
+	}
+	for _, s := range []string{
+		"HAS CHANGED",
+		ssh.FingerprintSHA256(other),
+		ssh.FingerprintSHA256(k),
+		file + ":1",
+		"boring known-hosts remove [127.0.0.1]:2222",
+	} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("explanation does not contain %q: %v", s, err)
+		}
+	}
+}
+
+func TestFindAndRemoveKnownHosts(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	k1, k2, k3 := edPub(t), edPub(t), edPub(t)
+	content := "# comment\n" +
+		knownhosts.Line([]string{"other"}, k1) + "\n" +
+		knownhosts.Line([]string{knownhosts.HashHostname("[127.0.0.1]:2222")}, k2) + "\n" +
+		"@cert-authority " + knownhosts.Line([]string{"[127.0.0.1]:2222"}, k3) + "\n"
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	files := []string{file, filepath.Join(t.TempDir(), "missing")}
+
+	all, err := FindKnownHosts(files, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(all))
+	}
+
+	found, err := FindKnownHosts(files, "[127.0.0.1]:2222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 || found[0].Line != 3 || found[0].Hosts[0] != "[127.0.0.1]:2222" {
+		t.Fatalf("unexpected entries: %+v", found)
+	}
+
+	removed, err := RemoveKnownHost(files, "[127.0.0.1]:2222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The hashed entry is removed, the CA entry kept
+	if len(removed) != 1 || removed[0].Line != 3 {
+		t.Fatalf("unexpected removed entries: %+v", removed)
+	}
+	left, err := FindKnownHosts(files, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 2 || left[1].Marker != "cert-authority" {
+		t.Fatalf("unexpected remaining entries: %+v", left)
+	}
+	if b, err := os.ReadFile(file + ".old"); err != nil || string(b) != content {
+		t.Errorf("backup not kept: %v", err)
+	}
+}
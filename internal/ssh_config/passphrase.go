@@ -0,0 +1,188 @@
+package ssh_config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/paths"
+	"golang.org/x/crypto/ssh"
+)
+
+// keyCache holds decrypted private keys, such that re-connections don't
+// require to enter passphrases again. Entries are invalidated once they
+// expire or the key file changes.
+var keyCache = struct {
+	sync.Mutex
+	m map[string]cachedKey
+}{m: make(map[string]cachedKey)}
+
+type cachedKey struct {
+	signer  ssh.Signer
+	modTime time.Time
+	expires time.Time // zero if never
+}
+
+func cachedSigner(path string, modTime time.Time) ssh.Signer {
+	keyCache.Lock()
+	defer keyCache.Unlock()
+	k, ok := keyCache.m[path]
+	if !ok {
+		return nil
+	}
+	if !k.modTime.Equal(modTime) || (!k.expires.IsZero() && time.Now().After(k.expires)) {
+		delete(keyCache.m, path)
+		return nil
+	}
+	return k.signer
+}
+
+func cacheSigner(path string, modTime time.Time, s ssh.Signer, ttl time.Duration) {
+	if ttl == 0 {
+		return
+	}
+	k := cachedKey{signer: s, modTime: modTime}
+	if ttl > 0 {
+		k.expires = time.Now().Add(ttl)
+	}
+	keyCache.Lock()
+	defer keyCache.Unlock()
+	keyCache.m[path] = k
+}
+
+// ClearKeyCache removes all decrypted keys from the cache
+func ClearKeyCache() {
+	keyCache.Lock()
+	defer keyCache.Unlock()
+	keyCache.m = make(map[string]cachedKey)
+}
+
+// encryptedKey is a signer for a passphrase-protected key file. It is only
+// decrypted once a signature is needed, i.e., after the server accepted
+// the public key. This way, no passphrase is asked for keys which are
+// not used, e.g., because they are also held by the agent.
+type encryptedKey struct {
+	path string
+	pub  ssh.PublicKey
+	sc   *SSHConfig
+}
+
+func (k *encryptedKey) PublicKey() ssh.PublicKey {
+	return k.pub
+}
+
+func (k *encryptedKey) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	s, err := k.sc.decryptKey(k.path)
+	if err != nil {
+		return nil, err
+	}
+	return s.Sign(rand, data)
+}
+
+func (k *encryptedKey) SignWithAlgorithm(rand io.Reader, data []byte,
+	algorithm string) (*ssh.Signature, error) {
+	s, err := k.sc.decryptKey(k.path)
+	if err != nil {
+		return nil, err
+	}
+	as, ok := s.(ssh.AlgorithmSigner)
+	if !ok {
+		return nil, fmt.Errorf("key %v does not support algorithm %v", k.path, algorithm)
+	}
+	return as.SignWithAlgorithm(rand, data, algorithm)
+}
+
+func (k *encryptedKey) String() string {
+	return fmt.Sprintf("%v (encrypted)", k.path)
+}
+
+// loadEncryptedKey returns a signer for a passphrase-protected key file,
+// or nil if the key can neither be found in the cache nor be decrypted
+// via sc.Prompt.
+func (sc *SSHConfig) loadEncryptedKey(path string, e *ssh.PassphraseMissingError) ssh.Signer {
+	if s, err := sc.cachedKey(path); err == nil && s != nil {
+		return s
+	}
+	if sc.Prompt == nil {
+		log.Debugf("%s: cannot ask for passphrase of key %q", sc.Alias, path)
+		return nil
+	}
+	pub := e.PublicKey
+	if pub == nil {
+		// Some key formats don't include the public key unencrypted
+		var err error
+		if pub, err = loadPublicKey(path + ".pub"); err != nil {
+			s, err := sc.decryptKey(path)
+			if err != nil {
+				log.Warningf("%s: %v", sc.Alias, err)
+				return nil
+			}
+			return s
+		}
+	}
+	return &encryptedKey{path: path, pub: pub, sc: sc}
+}
+
+func (sc *SSHConfig) cachedKey(path string) (ssh.Signer, error) {
+	abs, fi, err := statKey(path)
+	if err != nil {
+		return nil, err
+	}
+	return cachedSigner(abs, fi.ModTime()), nil
+}
+
+// decryptKey returns the decrypted key from the cache, or asks for its
+// passphrase via sc.Prompt and caches the result.
+func (sc *SSHConfig) decryptKey(path string) (ssh.Signer, error) {
+	abs, fi, err := statKey(path)
+	if err != nil {
+		return nil, err
+	}
+	if s := cachedSigner(abs, fi.ModTime()); s != nil {
+		return s, nil
+	}
+	if sc.Prompt == nil {
+		return nil, fmt.Errorf("passphrase required for key %v", path)
+	}
+	raw, err := os.ReadFile(abs)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key: %v", err)
+	}
+
+	for i := 0; i < sc.passwordPrompts(); i++ {
+		pass, err := sc.Prompt(fmt.Sprintf("Enter passphrase for key '%v': ", path), false)
+		if err != nil {
+			return nil, err
+		}
+		s, err := ssh.ParsePrivateKeyWithPassphrase(raw, []byte(pass))
+		if err == nil {
+			cacheSigner(abs, fi.ModTime(), s, sc.PassphraseCache)
+			return s, nil
+		}
+		log.Debugf("%s: could not decrypt key %q: %v", sc.Alias, path, err)
+	}
+	return nil, fmt.Errorf("could not decrypt key %v: bad passphrase", path)
+}
+
+func statKey(path string) (string, os.FileInfo, error) {
+	abs, err := filepath.Abs(paths.ReplaceTilde(path))
+	if err != nil {
+		return "", nil, err
+	}
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not read key: %v", err)
+	}
+	return abs, fi, nil
+}
+
+func passphraseMissing(err error) (*ssh.PassphraseMissingError, bool) {
+	var e *ssh.PassphraseMissingError
+	ok := errors.As(err, &e)
+	return e, ok
+}
@@ -0,0 +1,138 @@
+package ssh_config
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alebeck/boring/internal/log"
+	"golang.org/x/crypto/ssh"
+)
+
+func writeEncryptedKey(t *testing.T, pass string) string {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(pass))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "id_enc")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// countingPrompt answers with the given passphrases in turn
+func countingPrompt(n *int, answers ...string) Prompter {
+	return func(string, bool) (string, error) {
+		if *n >= len(answers) {
+			return "", fmt.Errorf("no more answers")
+		}
+		*n++
+		return answers[*n-1], nil
+	}
+}
+
+func TestEncryptedKeyNoPrompt(t *testing.T) {
+	log.Init(io.Discard, false, false)
+	ClearKeyCache()
+	path := writeEncryptedKey(t, "pass")
+
+	s, _, ok := (&SSHConfig{}).loadIdentity(path)
+	if ok || s != nil {
+		t.Fatalf("expected encrypted key to be skipped, got %v", s)
+	}
+}
+
+func TestEncryptedKeyDecryptedOnSign(t *testing.T) {
+	log.Init(io.Discard, false, false)
+	ClearKeyCache()
+	path := writeEncryptedKey(t, "pass")
+
+	var n int
+	sc := &SSHConfig{Prompt: countingPrompt(&n, "wrong", "pass"), PassphraseCache: -1}
+	s, _, ok := sc.loadIdentity(path)
+	if !ok || s == nil {
+		t.Fatal("expected signer for encrypted key")
+	}
+	if n != 0 {
+		t.Fatalf("expected no prompt before signing, got %d", n)
+	}
+	sig, err := s.Sign(rand.Reader, []byte("data"))
+	if err != nil {
+		t.Fatalf("could not sign: %v", err)
+	}
+	if err := s.PublicKey().Verify([]byte("data"), sig); err != nil {
+		t.Fatalf("invalid signature: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 prompts, got %d", n)
+	}
+
+	// Cached key is used without prompting
+	s, _, ok = (&SSHConfig{}).loadIdentity(path)
+	if !ok || s == nil {
+		t.Fatal("expected cached signer")
+	}
+	if _, err := s.Sign(rand.Reader, []byte("data")); err != nil {
+		t.Fatalf("could not sign: %v", err)
+	}
+}
+
+func TestEncryptedKeyBadPassphrase(t *testing.T) {
+	log.Init(io.Discard, false, false)
+	ClearKeyCache()
+	path := writeEncryptedKey(t, "pass")
+
+	var n int
+	sc := &SSHConfig{Prompt: countingPrompt(&n, "a", "b", "c", "d"), PasswordPrompts: 3}
+	if _, err := sc.decryptKey(path); err == nil {
+		t.Fatal("expected error for bad passphrase")
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 prompts, got %d", n)
+	}
+}
+
+func TestKeyCacheExpiry(t *testing.T) {
+	log.Init(io.Discard, false, false)
+	ClearKeyCache()
+	path := writeEncryptedKey(t, "pass")
+
+	var n int
+	sc := &SSHConfig{Prompt: countingPrompt(&n, "pass", "pass"),
+		PassphraseCache: 10 * time.Millisecond}
+	if _, err := sc.decryptKey(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sc.decryptKey(path); err != nil || n != 1 {
+		t.Fatalf("expected cached key, got %v after %d prompts", err, n)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := sc.decryptKey(path); err != nil || n != 2 {
+		t.Fatalf("expected expired key, got %v after %d prompts", err, n)
+	}
+}
+
+func TestKeyCacheDisabled(t *testing.T) {
+	log.Init(io.Discard, false, false)
+	ClearKeyCache()
+	path := writeEncryptedKey(t, "pass")
+
+	var n int
+	sc := &SSHConfig{Prompt: countingPrompt(&n, "pass", "pass")}
+	sc.decryptKey(path)
+	sc.decryptKey(path)
+	if n != 2 {
+		t.Fatalf("expected 2 prompts, got %d", n)
+	}
+}
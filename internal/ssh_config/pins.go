@@ -0,0 +1,214 @@
+package ssh_config
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	ossh_config "github.com/alebeck/ssh_config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyPins verify host keys of a hop without known_hosts. A host key is
+// accepted if it is one of Keys, matches one of Fingerprints, or is a
+// certificate signed by one of CAs.
+type HostKeyPins struct {
+	Keys         []ssh.PublicKey
+	Fingerprints []string
+	CAs          []ssh.PublicKey
+}
+
+// ScopedPins are pinned host keys as configured for a tunnel. Each pin
+// applies to the hops matching its host patterns, or only to the target
+// host if it has none.
+type ScopedPins []scopedPin
+
+type scopedPin struct {
+	// hosts are known_hosts patterns, such as "jump.example.com" or
+	// "[*.example.com]:2222"
+	hosts       []string
+	key         ssh.PublicKey
+	fingerprint string
+	ca          bool
+}
+
+// ParseHostKeyPins parses host keys and CA keys given in authorized_keys
+// format, and SHA256 fingerprints as shown by ssh-keygen -l. Each of them
+// may be preceded by host patterns like in known_hosts. It returns nil if
+// nothing is pinned.
+func ParseHostKeyPins(keys, fingerprints, cas []string) (ScopedPins, error) {
+	var p ScopedPins
+	for _, k := range keys {
+		hosts, pub, err := parsePinnedKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host key: %v", err)
+		}
+		p = append(p, scopedPin{hosts: hosts, key: pub})
+	}
+	for _, fp := range fingerprints {
+		hosts, fp, err := parsePinnedFingerprint(fp)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, scopedPin{hosts: hosts, fingerprint: fp})
+	}
+	for _, k := range cas {
+		hosts, pub, err := parsePinnedKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host CA: %v", err)
+		}
+		p = append(p, scopedPin{hosts: hosts, key: pub, ca: true})
+	}
+	return p, nil
+}
+
+// forHop returns the pins which apply to the given host, nil if there are
+// none and the host is verified via known_hosts
+func (s ScopedPins) forHop(host string, port int, jump bool) *HostKeyPins {
+	var p *HostKeyPins
+	for _, pin := range s {
+		if len(pin.hosts) == 0 && jump || len(pin.hosts) > 0 && !matchPatterns(pin.hosts, host, port) {
+			continue
+		}
+		if p == nil {
+			p = &HostKeyPins{}
+		}
+		switch {
+		case pin.ca:
+			p.CAs = append(p.CAs, pin.key)
+		case pin.key != nil:
+			p.Keys = append(p.Keys, pin.key)
+		default:
+			p.Fingerprints = append(p.Fingerprints, pin.fingerprint)
+		}
+	}
+	return p
+}
+
+// matchPatterns tells whether the known_hosts patterns match the host, given
+// either by name or as [host]:port for non-standard ports
+func matchPatterns(patterns []string, host string, port int) bool {
+	entries := []string{host, knownhosts.Normalize(net.JoinHostPort(host, strconv.Itoa(port)))}
+	found := false
+	for _, pat := range patterns {
+		negated := strings.HasPrefix(pat, "!")
+		for _, e := range entries {
+			if matchPattern(strings.TrimPrefix(pat, "!"), e) {
+				if negated {
+					return false
+				}
+				found = true
+			}
+		}
+	}
+	return found
+}
+
+func matchPattern(pattern, entry string) bool {
+	if pattern == entry || hashMatches(pattern, entry) {
+		return true
+	}
+	pat, err := ossh_config.NewPattern(pattern)
+	if err != nil {
+		return false
+	}
+	h := &ossh_config.Host{Patterns: []*ossh_config.Pattern{pat}}
+	return h.Matches(&ossh_config.MatchContext{OriginalHost: entry})
+}
+
+// String lists the pins, e.g., for telling apart hops pinned differently
+func (p *HostKeyPins) String() string {
+	if p == nil {
+		return "none"
+	}
+	var b strings.Builder
+	for _, k := range p.Keys {
+		fmt.Fprintf(&b, "key %v ", ssh.FingerprintSHA256(k))
+	}
+	for _, fp := range p.Fingerprints {
+		fmt.Fprintf(&b, "fp %v ", fp)
+	}
+	for _, k := range p.CAs {
+		fmt.Fprintf(&b, "ca %v ", ssh.FingerprintSHA256(k))
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// parsePinnedKey parses a key like "ssh-ed25519 AAAA...", optionally with
+// a comment, or a known_hosts line with host patterns the key applies to
+func parsePinnedKey(s string) (hosts []string, pub ssh.PublicKey, err error) {
+	_, hosts, pub, _, _, err = ssh.ParseKnownHosts([]byte(s))
+	if err != nil {
+		hosts = nil
+		pub, _, _, _, err = ssh.ParseAuthorizedKey([]byte(s))
+	}
+	return hosts, pub, err
+}
+
+// parsePinnedFingerprint parses a fingerprint like "SHA256:...", optionally
+// preceded by comma-separated host patterns
+func parsePinnedFingerprint(s string) (hosts []string, fp string, err error) {
+	fields := strings.Fields(s)
+	if len(fields) == 2 {
+		hosts = strings.Split(fields[0], ",")
+		fields = fields[1:]
+	}
+	if len(fields) != 1 || !strings.HasPrefix(fields[0], "SHA256:") {
+		return nil, "", fmt.Errorf("invalid host key fingerprint %q: "+
+			"expected SHA256 fingerprint", s)
+	}
+	return hosts, fields[0], nil
+}
+
+// algos returns the host key algorithms of allowed, in order, which can
+// be verified by the pins
+func (p *HostKeyPins) algos(allowed []string) []string {
+	var want []string
+	if len(p.CAs) > 0 {
+		want = append(want, allCertAlgos...)
+	}
+	if len(p.Fingerprints) > 0 {
+		// The key type is unknown
+		want = append(want, withoutCerts(allowed)...)
+	}
+	for _, k := range p.Keys {
+		want = append(want, k.Type())
+		if k.Type() == ssh.KeyAlgoRSA {
+			want = append(want, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512)
+		}
+	}
+	return filter(allowed, want)
+}
+
+func (p *HostKeyPins) check(host string, remote net.Addr, key ssh.PublicKey) error {
+	if _, ok := key.(*ssh.Certificate); ok {
+		checker := &ssh.CertChecker{
+			IsHostAuthority: func(auth ssh.PublicKey, _ string) bool {
+				return containsKey(p.CAs, auth)
+			},
+		}
+		return checker.CheckHostKey(host, remote, key)
+	}
+	if containsKey(p.Keys, key) {
+		return nil
+	}
+	fp := ssh.FingerprintSHA256(key)
+	for _, f := range p.Fingerprints {
+		if f == fp {
+			return nil
+		}
+	}
+	return fmt.Errorf("%v host key of %v (%v) does not match pinned keys", key.Type(), host, fp)
+}
+
+func containsKey(keys []ssh.PublicKey, key ssh.PublicKey) bool {
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
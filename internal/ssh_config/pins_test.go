@@ -0,0 +1,150 @@
+package ssh_config
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func edSigner(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// targetPins parses pins and returns those verifying the target host
+func targetPins(t *testing.T, keys, fingerprints, cas []string) *HostKeyPins {
+	s, err := ParseHostKeyPins(keys, fingerprints, cas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.forHop("127.0.0.1", 2222, false)
+}
+
+func TestParseHostKeyPinsEmpty(t *testing.T) {
+	p, err := ParseHostKeyPins(nil, nil, nil)
+	if err != nil || p != nil {
+		t.Fatalf("expected no pins, got %v, %v", p, err)
+	}
+}
+
+func TestParseHostKeyPinsInvalid(t *testing.T) {
+	if _, err := ParseHostKeyPins([]string{"not a key"}, nil, nil); err == nil {
+		t.Error("expected error for invalid key")
+	}
+	if _, err := ParseHostKeyPins(nil, []string{"aa:bb:cc"}, nil); err == nil {
+		t.Error("expected error for non-SHA256 fingerprint")
+	}
+	if _, err := ParseHostKeyPins(nil, []string{"a b SHA256:x"}, nil); err == nil {
+		t.Error("expected error for malformed fingerprint")
+	}
+	if _, err := ParseHostKeyPins(nil, nil, []string{"nope"}); err == nil {
+		t.Error("expected error for invalid CA")
+	}
+}
+
+func TestHostKeyPinsCheck(t *testing.T) {
+	k, other := edPub(t), edPub(t)
+	line := string(ssh.MarshalAuthorizedKey(k))
+
+	p := targetPins(t, []string{line}, nil, nil)
+	if err := p.check(testHostPort, &net.TCPAddr{}, k); err != nil {
+		t.Errorf("pinned key rejected: %v", err)
+	}
+	if err := p.check(testHostPort, &net.TCPAddr{}, other); err == nil {
+		t.Error("other key accepted")
+	}
+
+	p = targetPins(t, nil, []string{ssh.FingerprintSHA256(k)}, nil)
+	if err := p.check(testHostPort, &net.TCPAddr{}, k); err != nil {
+		t.Errorf("pinned fingerprint rejected: %v", err)
+	}
+	if err := p.check(testHostPort, &net.TCPAddr{}, other); err == nil {
+		t.Error("other key accepted")
+	}
+}
+
+func TestHostKeyPinsCA(t *testing.T) {
+	ca, host := edSigner(t), edSigner(t)
+	cert := &ssh.Certificate{
+		Key:             host.PublicKey(),
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{"127.0.0.1"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+
+	p := targetPins(t, nil, nil, []string{string(ssh.MarshalAuthorizedKey(ca.PublicKey()))})
+	if err := p.check(testHostPort, &net.TCPAddr{}, cert); err != nil {
+		t.Errorf("certificate rejected: %v", err)
+	}
+	if err := p.check("other:22", &net.TCPAddr{}, cert); err == nil {
+		t.Error("certificate accepted for wrong principal")
+	}
+	if err := p.check(testHostPort, &net.TCPAddr{}, host.PublicKey()); err == nil {
+		t.Error("plain key accepted with CA only")
+	}
+}
+
+func TestHostKeyPinsAlgos(t *testing.T) {
+	allowed := []string{ssh.CertAlgoED25519v01, ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256}
+	p := &HostKeyPins{Keys: []ssh.PublicKey{edPub(t)}}
+	if a := p.algos(allowed); !reflect.DeepEqual(a, []string{ssh.KeyAlgoED25519}) {
+		t.Errorf("unexpected algorithms for keys: %v", a)
+	}
+	p = &HostKeyPins{Fingerprints: []string{"SHA256:x"}}
+	if a := p.algos(allowed); !reflect.DeepEqual(a, allowed[1:]) {
+		t.Errorf("unexpected algorithms for fingerprints: %v", a)
+	}
+	p = &HostKeyPins{CAs: []ssh.PublicKey{edPub(t)}}
+	if a := p.algos(allowed); !reflect.DeepEqual(a, allowed[:1]) {
+		t.Errorf("unexpected algorithms for CAs: %v", a)
+	}
+}
+
+func TestScopedPins(t *testing.T) {
+	target, jump, ca := edPub(t), edPub(t), edPub(t)
+	s, err := ParseHostKeyPins(
+		[]string{
+			string(ssh.MarshalAuthorizedKey(target)),
+			"[jump.example.com]:2222 " + string(ssh.MarshalAuthorizedKey(jump)),
+		},
+		[]string{"*.internal,!db.internal " + ssh.FingerprintSHA256(jump)},
+		[]string{"@cert-authority *.example.com " + string(ssh.MarshalAuthorizedKey(ca))},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		host string
+		port int
+		jump bool
+		want *HostKeyPins
+	}{
+		{"target.com", 22, false, &HostKeyPins{Keys: []ssh.PublicKey{target}}},
+		{"target.com", 22, true, nil},
+		{"jump.example.com", 2222, true, &HostKeyPins{Keys: []ssh.PublicKey{jump},
+			CAs: []ssh.PublicKey{ca}}},
+		{"jump.example.com", 22, true, &HostKeyPins{CAs: []ssh.PublicKey{ca}}},
+		{"web.internal", 22, true, &HostKeyPins{Fingerprints: []string{ssh.FingerprintSHA256(jump)}}},
+		{"db.internal", 22, true, nil},
+	}
+	for _, c := range cases {
+		if got := s.forHop(c.host, c.port, c.jump); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("forHop(%v, %v, %v) = %v, want %v", c.host, c.port, c.jump, got, c.want)
+		}
+	}
+}
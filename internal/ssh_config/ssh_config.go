@@ -1,6 +1,9 @@
 package ssh_config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -33,16 +36,45 @@ const (
 	strict keyCheck = iota
 	// Accepts all hosts, this corresponds to "no" and "off" options
 	off
-	// TODO: support "accept-new" option?
+	// Adds keys of unknown hosts to the known hosts, but rejects changed
+	// keys. This corresponds to the "accept-new" option.
+	acceptNew
 )
 
 // Hop holds information needed to establish a single SSH hop
 type Hop struct {
 	HostName string
 	Port     int
+	// ProxyCommand, if set, is run to connect to the hop
+	ProxyCommand string
+	// KeepAlive is the keep-alive interval, KeepAliveCountMax the number of
+	// keep-alives which may go unanswered before the connection is closed
+	KeepAlive         time.Duration
+	KeepAliveCountMax int
+	// Attempts is the number of times to try connecting to the hop
+	Attempts int
+	// CertExpiry is the earliest expiry of the certificates offered to the
+	// hop, zero if none is offered. CertKey is the fingerprint of the key
+	// certified by the certificate expiring then.
+	CertExpiry time.Time
+	CertKey    string
+	// SettingsID identifies the settings used to authenticate to and verify
+	// the hop, such that clients are only shared between equal settings
+	SettingsID string
 	*ssh.ClientConfig
+	// hostKeys, if set, updates known hosts on host key announcements
+	hostKeys *hostKeyUpdate
 }
 
+// TrustFunc is called with the key of each host connected to. Unknown keys
+// are added to the known hosts if it returns true, known keys are reported
+// for information only.
+type TrustFunc func(host string, key ssh.PublicKey, known bool) bool
+
+// Prompter answers authentication prompts, e.g., for passwords. If echo
+// is set, the answer does not need to be hidden.
+type Prompter func(prompt string, echo bool) (string, error)
+
 // SSHConfig represents an SSH config read from, e.g., ~/.ssh/config
 type SSHConfig struct {
 	Alias            string
@@ -53,17 +85,54 @@ type SSHConfig struct {
 	IdentitiesOnly   bool
 	IdentityFiles    []string
 	CertificateFiles []string
-	KnownHostsFiles  []string
-	Ciphers          []string
-	Macs             []string
-	HostKeyAlgos     []string
-	KexAlgos         []string
-	Jumps            []*jumpSpec
+	// IdentityAgents are the sockets of the agents to use, if any
+	IdentityAgents []string
+	// fixedAgents is set if IdentityAgents apply to jump hosts as well
+	fixedAgents     bool
+	KnownHostsFiles []string
+	// UserKnownHostsFile is the file new host keys are added to
+	UserKnownHostsFile string
+	HashKnownHosts     bool
+	UpdateHostKeys     string
+	// updateHostKeysSet tells whether UpdateHostKeys is configured, rather
+	// than being the default
+	updateHostKeysSet bool
+	Ciphers           []string
+	Macs              []string
+	HostKeyAlgos      []string
+	KexAlgos          []string
+	Jumps             []*jumpSpec
+	ProxyCommand      string
+	PreferredAuths    []string
+	PasswordPrompts   int
+	// ServerAliveInterval and ConnectTimeout are zero if not configured
+	ServerAliveInterval time.Duration
+	ServerAliveCountMax int
+	ConnectTimeout      time.Duration
+	ConnectionAttempts  int
+	// Prompt answers password and keyboard-interactive prompts, as
+	// well as passphrases of keys. If nil, only public key authentication
+	// with unencrypted or cached keys is used.
+	Prompt Prompter
+	// PassphraseCache is the time for which decrypted keys are cached,
+	// negative values cache them until the daemon exits.
+	PassphraseCache time.Duration
+	// Trust, if set, decides about unknown host keys instead of KeyCheck
+	Trust TrustFunc
+	// Pins, if any apply to the host, verify its host key instead of the
+	// known hosts
+	Pins ScopedPins
+	// Jump is set for hosts jumped through to reach the target host
+	Jump bool
+	// certExpiry and certKey are set by makeSigners, see Hop.CertExpiry
+	certExpiry time.Time
+	certKey    string
 }
 
 var (
 	hostnameTokens  = []string{"%%", "%h"}
 	proxyTokens     = []string{"%%", "h", "%n", "%p", "%r"}
+	proxyCmdTokens  = []string{"%%", "%d", "%h", "%L", "%n", "%p", "%r", "%u"}
 	identFileTokens = []string{
 		"%%", "%d", "%h", "%i", "%j", "%k",
 		"%L", "%l", "%n", "%p", "%r", "%u",
@@ -86,7 +155,52 @@ func ParseSSHConfig(alias, user string) (*SSHConfig, error) {
 	// In the following, we always provide `user` since it is needed for `Match` matching
 	get := func(key string) string { return us.Get(alias, key, user) }
 	getAll := func(key string) []string { return us.GetAll(alias, key, user) }
+	c, err := parse(alias, get, getAll)
+	if err != nil {
+		return nil, err
+	}
+	c.updateHostKeysSet = configured(alias, user, "UpdateHostKeys")
+	return c, nil
+}
 
+// configured tells whether key is set for alias in the ssh_config files,
+// since the values returned for keys which are not set are their defaults
+func configured(alias, user, key string) bool {
+	files := []string{overrideConfig}
+	if overrideConfig == "" {
+		files = []string{paths.ReplaceTilde("~/.ssh/config"), "/etc/ssh/ssh_config"}
+	}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		cfg, err := ossh_config.DecodeBytes(b)
+		if err != nil {
+			continue
+		}
+		if v, _ := cfg.Get(key, ossh_config.NewMatchContext(alias, user)); v != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultSSHConfig returns the configuration of ssh(1) for a host which is
+// not configured in any ssh_config file. It is meant for hosts which are
+// defined elsewhere, e.g., in the boring config.
+func DefaultSSHConfig(alias string) (*SSHConfig, error) {
+	getAll := func(key string) []string {
+		if v := ossh_config.Default(key); v != "" {
+			return []string{v}
+		}
+		return nil
+	}
+	return parse(alias, ossh_config.Default, getAll)
+}
+
+func parse(alias string, get func(string) string,
+	getAll func(string) []string) (*SSHConfig, error) {
 	c := &SSHConfig{Alias: alias}
 	sub := makeSubst(alias)
 
@@ -99,15 +213,8 @@ func ParseSSHConfig(alias, user string) (*SSHConfig, error) {
 	c.Port, _ = strconv.Atoi(get("Port"))
 	sub["%p"] = fmt.Sprintf("%d", c.Port)
 
-	s := get("StrictHostKeyChecking")
-	if s == "no" || s == "off" {
-		c.KeyCheck = off
-	} else if s == "accept-new" {
-		log.Warningf(
-			"StrictHostKeyChecking 'accept-new' not supported, using 'yes'")
-	} else if s != "yes" && s != "ask" {
-		return nil, fmt.Errorf(
-			"unsupported StrictHostKeyChecking option '%v'", s)
+	if err := c.SetStrictHostKeyChecking(get("StrictHostKeyChecking")); err != nil {
+		return nil, err
 	}
 
 	c.Ciphers = split(get("Ciphers"))
@@ -128,25 +235,83 @@ func ParseSSHConfig(alias, user string) (*SSHConfig, error) {
 		}
 	}
 
+	// Proxy command, ProxyJump takes precedence if both are given
+	if pc := get("ProxyCommand"); pc != "" && pc != "none" {
+		if len(c.Jumps) > 0 {
+			log.Warningf("%v: ignoring ProxyCommand since ProxyJump is set", alias)
+		} else {
+			// Substituted in toHopsImpl, once user and port are final
+			c.ProxyCommand = pc
+		}
+	}
+
+	c.PreferredAuths = split(get("PreferredAuthentications"))
+	c.PasswordPrompts, _ = strconv.Atoi(get("NumberOfPasswordPrompts"))
+
+	// Times are given in seconds
+	alive, _ := strconv.Atoi(get("ServerAliveInterval"))
+	c.ServerAliveInterval = time.Duration(alive) * time.Second
+	timeout, _ := strconv.Atoi(get("ConnectTimeout"))
+	c.ConnectTimeout = time.Duration(timeout) * time.Second
+	c.ServerAliveCountMax, _ = strconv.Atoi(get("ServerAliveCountMax"))
+	c.ConnectionAttempts, _ = strconv.Atoi(get("ConnectionAttempts"))
+
 	c.IdentitiesOnly = get("IdentitiesOnly") == "yes"
 	c.IdentityFiles = sub.applyAll(getAll("IdentityFile"), identFileTokens)
 	c.CertificateFiles = getAll("CertificateFile")
+	c.IdentityAgents = ResolveIdentityAgents(
+		[]string{sub.apply(get("IdentityAgent"), identFileTokens)})
 
 	// Known hosts
 	hosts := getAll("GlobalKnownHostsFile")
-	hosts = append(hosts, sub.applyAll(getAll("UserKnownHostsFile"), identFileTokens)...)
+	var userHosts []string
+	for _, h := range sub.applyAll(getAll("UserKnownHostsFile"), identFileTokens) {
+		userHosts = append(userHosts, strings.Split(h, " ")...)
+	}
+	if len(userHosts) > 0 {
+		c.UserKnownHostsFile = userHosts[0]
+	}
 	for _, h := range hosts {
 		c.KnownHostsFiles = append(c.KnownHostsFiles, strings.Split(h, " ")...)
 	}
+	c.KnownHostsFiles = append(c.KnownHostsFiles, userHosts...)
+	c.HashKnownHosts = get("HashKnownHosts") == "yes"
+	c.UpdateHostKeys = get("UpdateHostKeys")
 
 	return c, nil
 }
 
+// SetStrictHostKeyChecking sets KeyCheck according to a value of the
+// StrictHostKeyChecking option
+func (sc *SSHConfig) SetStrictHostKeyChecking(s string) error {
+	switch s {
+	case "no", "off":
+		sc.KeyCheck = off
+	case "accept-new":
+		sc.KeyCheck = acceptNew
+	case "yes", "ask":
+		sc.KeyCheck = strict
+	default:
+		return fmt.Errorf("unsupported StrictHostKeyChecking option '%v'", s)
+	}
+	return nil
+}
+
 // ToHops creates an ordered series of Hops from an SSHConfig
 func (sc *SSHConfig) ToHops() ([]Hop, error) {
 	return sc.toHopsImpl(false, 0)
 }
 
+// ToHop creates a single Hop from an SSHConfig, ignoring its jump hosts
+// and proxy command
+func (sc *SSHConfig) ToHop() (Hop, error) {
+	hops, err := sc.toHopsImpl(true, 0)
+	if err != nil {
+		return Hop{}, err
+	}
+	return hops[0], nil
+}
+
 func (sc *SSHConfig) toHopsImpl(ignoreIntermediate bool, depth int) ([]Hop, error) {
 	if depth > maxJumpRecursions {
 		return nil, fmt.Errorf("maximum jump recursions exceeded")
@@ -158,6 +323,7 @@ func (sc *SSHConfig) toHopsImpl(ignoreIntermediate bool, depth int) ([]Hop, erro
 
 	if ignoreIntermediate {
 		sc.Jumps = nil
+		sc.ProxyCommand = ""
 	}
 
 	var hops []Hop
@@ -181,6 +347,14 @@ func (sc *SSHConfig) toHopsImpl(ignoreIntermediate bool, depth int) ([]Hop, erro
 		}
 
 		jc.EnsureUser()
+		jc.Prompt = sc.Prompt
+		jc.PassphraseCache = sc.PassphraseCache
+		jc.Trust = sc.Trust
+		jc.Pins = sc.Pins
+		jc.Jump = true
+		if sc.fixedAgents {
+			jc.OverrideIdentityAgents(sc.IdentityAgents)
+		}
 
 		// Recursively connect to first jump host, ignore jumps for subsequent connections;
 		// this corresponds to ssh(1) behavior
@@ -191,12 +365,10 @@ func (sc *SSHConfig) toHopsImpl(ignoreIntermediate bool, depth int) ([]Hop, erro
 		hops = append(hops, hs...)
 	}
 
-	sigs, err := sc.makeSigners()
+	auth, err := sc.makeAuth()
 	if err != nil {
 		return nil, err
 	}
-	log.Debugf("Trying %d key file(s)", len(sigs))
-	auth := []ssh.AuthMethod{ssh.PublicKeys(sigs...)}
 
 	keyCallback, keyAlgos, err := sc.makeCallbackAndAlgos()
 	if err != nil {
@@ -215,13 +387,37 @@ func (sc *SSHConfig) toHopsImpl(ignoreIntermediate bool, depth int) ([]Hop, erro
 		HostKeyCallback:   keyCallback,
 		Timeout:           sshConnTimeout,
 	}
+	if sc.ConnectTimeout > 0 {
+		clientConf.Timeout = sc.ConnectTimeout
+	}
 
-	hop := Hop{HostName: sc.HostName, Port: sc.Port, ClientConfig: clientConf}
+	hop := Hop{
+		HostName:          sc.HostName,
+		Port:              sc.Port,
+		ProxyCommand:      sc.proxyCommand(),
+		KeepAlive:         sc.ServerAliveInterval,
+		KeepAliveCountMax: max(sc.ServerAliveCountMax, 1),
+		Attempts:          max(sc.ConnectionAttempts, 1),
+		CertExpiry:        sc.certExpiry,
+		CertKey:           sc.certKey,
+		SettingsID:        sc.settingsID(),
+		ClientConfig:      clientConf,
+		hostKeys:          sc.hostKeyUpdate(),
+	}
 	hops = append(hops, hop)
 
 	return hops, nil
 }
 
+// settingsID summarizes the identities, agents and host key verification
+// of sc, see Hop.SettingsID
+func (sc *SSHConfig) settingsID() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%q %q %q %q %v %v %v", sc.IdentityFiles, sc.CertificateFiles,
+		sc.IdentityAgents, sc.KnownHostsFiles, sc.KeyCheck, sc.Trust != nil, sc.hopPins())
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
 func (sc *SSHConfig) loadCerts() (certs []*ssh.Certificate) {
 	for _, f := range sc.CertificateFiles {
 		cert, err := loadCert(f)
@@ -244,7 +440,7 @@ func (sc *SSHConfig) loadIDs() (fileIDs, agentCertIDs, agentCfgIDs, agentOtherID
 	cfgFP := make(map[string]struct{}, len(sc.IdentityFiles))
 
 	for _, f := range sc.IdentityFiles {
-		s, fp, ok := loadIdentity(f)
+		s, fp, ok := sc.loadIdentity(f)
 		if !ok {
 			log.Warningf("key file %q could not be added", f)
 			continue
@@ -255,33 +451,38 @@ func (sc *SSHConfig) loadIDs() (fileIDs, agentCertIDs, agentCfgIDs, agentOtherID
 		}
 	}
 
-	if agSigs, err := agent.GetSigners(); err != nil {
-		log.Warningf("Unable to get keys from ssh-agent: %v", err)
-	} else {
-		for _, s := range agSigs {
-			// Agent may return certificate identities (public key is a cert)
-			if c, ok := s.PublicKey().(*ssh.Certificate); ok {
-				fp := keyFP(c.Key)
-				if _, ok := cfgFP[fp]; ok || !sc.IdentitiesOnly {
-					agentCertIDs = append(agentCertIDs, identity{signer: s})
-				}
-				continue
+	var agSigs []ssh.Signer
+	for _, sock := range sc.IdentityAgents {
+		sigs, err := agent.GetSigners(sock)
+		if err != nil {
+			log.Warningf("Unable to get keys from ssh-agent %v: %v", sock, err)
+			continue
+		}
+		agSigs = append(agSigs, sigs...)
+	}
+	for _, s := range agSigs {
+		// Agent may return certificate identities (public key is a cert)
+		if c, ok := s.PublicKey().(*ssh.Certificate); ok {
+			fp := keyFP(c.Key)
+			if _, ok := cfgFP[fp]; ok || !sc.IdentitiesOnly {
+				agentCertIDs = append(agentCertIDs, identity{signer: s})
 			}
+			continue
+		}
 
-			id := identity{signer: s}
-			fp := keyFP(s.PublicKey())
-			if _, ok := cfgFP[fp]; ok {
-				agentCfgIDs = append(agentCfgIDs, id)
-				// Remove id from fileIDs if existing
-				for i, fid := range fileIDs {
-					if keyFP(fid.signer.PublicKey()) == fp {
-						fileIDs = append(fileIDs[:i], fileIDs[i+1:]...)
-						break
-					}
+		id := identity{signer: s}
+		fp := keyFP(s.PublicKey())
+		if _, ok := cfgFP[fp]; ok {
+			agentCfgIDs = append(agentCfgIDs, id)
+			// Remove id from fileIDs if existing
+			for i, fid := range fileIDs {
+				if keyFP(fid.signer.PublicKey()) == fp {
+					fileIDs = append(fileIDs[:i], fileIDs[i+1:]...)
+					break
 				}
-			} else if !sc.IdentitiesOnly {
-				agentOtherIDs = append(agentOtherIDs, id)
 			}
+		} else if !sc.IdentitiesOnly {
+			agentOtherIDs = append(agentOtherIDs, id)
 		}
 	}
 	return
@@ -355,37 +556,188 @@ func (sc *SSHConfig) makeSigners() ([]ssh.Signer, error) {
 		log.Debugf("%s: will try key %s", sc.Alias, sig)
 	}
 
+	if sc.certExpiry, sc.certKey = certExpiry(sigs); !sc.certExpiry.IsZero() &&
+		time.Now().After(sc.certExpiry) {
+		log.Warningf("%s: certificate expired at %v", sc.Alias, sc.certExpiry)
+	}
+
 	return sigs, nil
 }
 
-func (sc *SSHConfig) makeCallbackAndAlgos() (cb ssh.HostKeyCallback, algs []string, err error) {
-	if sc.KeyCheck == strict {
-		var hosts []string
-		for _, k := range sc.KnownHostsFiles {
-			k = paths.ReplaceTilde(k)
-			if _, err := os.Stat(k); err != nil {
-				log.Debugf("could not open known hosts file %v: %v", k, err)
+// makeAuth creates the authentication methods in the order given by
+// PreferredAuthentications. Methods that are not supported are skipped.
+func (sc *SSHConfig) makeAuth() ([]ssh.AuthMethod, error) {
+	var auth []ssh.AuthMethod
+	var keyErr error
+	for _, m := range sc.PreferredAuths {
+		switch m {
+		case "publickey":
+			sigs, err := sc.makeSigners()
+			if err != nil {
+				log.Debugf("%v: skipping public key authentication: %v", sc.Alias, err)
+				keyErr = err
+				continue
+			}
+			log.Debugf("Trying %d key file(s)", len(sigs))
+			auth = append(auth, ssh.PublicKeys(sigs...))
+		case "password":
+			if sc.Prompt == nil {
+				continue
+			}
+			prompt := fmt.Sprintf("%v@%v's password: ", sc.User, sc.HostName)
+			cb := ssh.PasswordCallback(func() (string, error) {
+				return sc.Prompt(prompt, false)
+			})
+			auth = append(auth, ssh.RetryableAuthMethod(cb, sc.passwordPrompts()))
+		case "keyboard-interactive":
+			if sc.Prompt == nil {
 				continue
 			}
-			hosts = append(hosts, k)
+			cb := ssh.KeyboardInteractive(sc.challenge)
+			auth = append(auth, ssh.RetryableAuthMethod(cb, sc.passwordPrompts()))
+		}
+	}
+	if len(auth) == 0 {
+		if keyErr != nil {
+			return nil, keyErr
+		}
+		return nil, fmt.Errorf("%s: no supported authentication methods in %v",
+			sc.Alias, sc.PreferredAuths)
+	}
+	return auth, nil
+}
+
+// challenge answers keyboard-interactive questions via sc.Prompt
+func (sc *SSHConfig) challenge(name, instruction string,
+	questions []string, echos []bool) ([]string, error) {
+	answers := make([]string, len(questions))
+	for i, q := range questions {
+		if i == 0 && instruction != "" {
+			q = instruction + "\n" + q
 		}
-		if cb, err = knownhosts.New(hosts...); err != nil {
-			return nil, nil, fmt.Errorf("knownhosts: %v", err)
+		a, err := sc.Prompt(q, echos[i])
+		if err != nil {
+			return nil, err
 		}
-		known := extractHostKeyAlgos(cb, net.JoinHostPort(sc.HostName, strconv.Itoa(sc.Port)))
-		algs = filter(sc.HostKeyAlgos, known)
+		answers[i] = a
+	}
+	return answers, nil
+}
+
+func (sc *SSHConfig) passwordPrompts() int {
+	if sc.PasswordPrompts <= 0 {
+		return 3
+	}
+	return sc.PasswordPrompts
+}
+
+// hopPins returns the pinned host keys which apply to the host
+func (sc *SSHConfig) hopPins() *HostKeyPins {
+	return sc.Pins.forHop(sc.HostName, sc.Port, sc.Jump)
+}
+
+func (sc *SSHConfig) makeCallbackAndAlgos() (cb ssh.HostKeyCallback, algs []string, err error) {
+	if pins := sc.hopPins(); pins != nil {
+		if algs = pins.algos(sc.HostKeyAlgos); len(algs) == 0 {
+			return nil, nil, fmt.Errorf("%v: none of the host key algorithms %v can be "+
+				"verified by the pinned keys", sc.Alias, sc.HostKeyAlgos)
+		}
+		log.Debugf("%v: using pinned host keys, trying: %v", sc.Alias, algs)
+		return pins.check, algs, nil
+	}
+	if sc.KeyCheck == off && sc.Trust == nil {
+		return ssh.InsecureIgnoreHostKey(), sc.HostKeyAlgos, nil
+	}
+
+	if cb, err = sc.knownHostsCallback(); err != nil {
+		return nil, nil, err
+	}
+	known := extractHostKeyAlgos(cb, net.JoinHostPort(sc.HostName, strconv.Itoa(sc.Port)))
+	algs = filter(sc.HostKeyAlgos, known)
+
+	if sc.KeyCheck == acceptNew || sc.Trust != nil {
 		if len(algs) == 0 {
-			return nil, nil, fmt.Errorf("%v: could not determine host key algorithms: default are %v, "+
-				"available in known_hosts are %v. %v%vNote that boring does not automatically add keys to "+
-				"your known_hosts.%v", sc.Alias, sc.HostKeyAlgos, known, log.Bold, log.Red, log.Reset)
+			// Host is unknown, accept any plain key type, since there
+			// is no authority to check certificates against
+			algs = withoutCerts(sc.HostKeyAlgos)
 		}
-		log.Debugf("%v: key types in known_hosts: %v, configured: %v, trying: %v",
-			sc.Alias, known, sc.HostKeyAlgos, algs)
-	} else if sc.KeyCheck == off {
-		cb = ssh.InsecureIgnoreHostKey()
-		algs = sc.HostKeyAlgos
+		log.Debugf("%v: key types in known_hosts: %v, trying: %v", sc.Alias, known, algs)
+		return sc.acceptNewCallback(), algs, nil
 	}
-	return
+
+	if len(algs) == 0 {
+		return nil, nil, fmt.Errorf("%v: could not determine host key algorithms: default are %v, "+
+			"available in known_hosts are %v. %v%vAdd the host key via `boring trust <tunnel>`, "+
+			"or set StrictHostKeyChecking to accept-new.%v", sc.Alias, sc.HostKeyAlgos, known,
+			log.Bold, log.Red, log.Reset)
+	}
+	log.Debugf("%v: key types in known_hosts: %v, configured: %v, trying: %v",
+		sc.Alias, known, sc.HostKeyAlgos, algs)
+	return explainKeyErrors(cb), algs, nil
+}
+
+// knownHostsCallback checks host keys against the existing known hosts files
+func (sc *SSHConfig) knownHostsCallback() (ssh.HostKeyCallback, error) {
+	var hosts []string
+	for _, k := range sc.KnownHostsFiles {
+		k = paths.ReplaceTilde(k)
+		if _, err := os.Stat(k); err != nil {
+			log.Debugf("could not open known hosts file %v: %v", k, err)
+			continue
+		}
+		hosts = append(hosts, k)
+	}
+	cb, err := knownhosts.New(hosts...)
+	if err != nil {
+		return nil, fmt.Errorf("knownhosts: %v", err)
+	}
+	return cb, nil
+}
+
+// acceptNewCallback adds keys of unknown hosts to the user known hosts
+// file, if accepted by sc.Trust or due to accept-new, and rejects changed
+// keys. The known hosts are re-read on each call, such that keys added
+// meanwhile are taken into account.
+func (sc *SSHConfig) acceptNewCallback() ssh.HostKeyCallback {
+	return func(host string, remote net.Addr, key ssh.PublicKey) error {
+		cb, err := sc.knownHostsCallback()
+		if err != nil {
+			return err
+		}
+		err = cb(host, remote, key)
+		var ke *knownhosts.KeyError
+		if !errors.As(err, &ke) || len(ke.Want) > 0 {
+			// Known or changed key, or other error
+			if err == nil && sc.Trust != nil {
+				sc.Trust(host, key, true)
+			}
+			return explainKeyError(host, key, err)
+		}
+		if sc.Trust != nil && !sc.Trust(host, key, false) {
+			return fmt.Errorf("host key of %v not trusted", host)
+		}
+		if sc.UserKnownHostsFile == "" {
+			return fmt.Errorf("no known hosts file to add the key of %v to", host)
+		}
+		if err := addKnownHost(sc.UserKnownHostsFile, host, key, sc.HashKnownHosts); err != nil {
+			return fmt.Errorf("could not add host key: %v", err)
+		}
+		log.Infof("%v: permanently added %v key of %v to %v", sc.Alias, key.Type(),
+			host, sc.UserKnownHostsFile)
+		return nil
+	}
+}
+
+// proxyCommand returns the ProxyCommand with tokens substituted
+func (sc *SSHConfig) proxyCommand() string {
+	if sc.ProxyCommand == "" {
+		return ""
+	}
+	sub := makeSubst(sc.Alias)
+	sub["%h"] = sc.HostName
+	sub["%p"] = strconv.Itoa(sc.Port)
+	sub["%r"] = sc.User
+	return sub.apply(sc.ProxyCommand, proxyCmdTokens)
 }
 
 func (sc *SSHConfig) validate() error {
@@ -401,6 +753,35 @@ func (sc *SSHConfig) validate() error {
 	return nil
 }
 
+// OverrideIdentityAgents replaces the agents of sc and its jump hosts
+func (sc *SSHConfig) OverrideIdentityAgents(agents []string) {
+	sc.IdentityAgents = agents
+	sc.fixedAgents = true
+}
+
+// ResolveIdentityAgents resolves IdentityAgent values to agent sockets.
+// Like ssh(1), "SSH_AUTH_SOCK" and empty values refer to $SSH_AUTH_SOCK,
+// values starting with "$" to other environment variables, and "none"
+// disables agents.
+func ResolveIdentityAgents(specs []string) (socks []string) {
+	for _, s := range specs {
+		switch {
+		case s == "none":
+			return nil
+		case s == "" || s == "SSH_AUTH_SOCK":
+			s = os.Getenv("SSH_AUTH_SOCK")
+		case strings.HasPrefix(s, "$"):
+			s = os.ExpandEnv(s)
+		}
+		if s == "" {
+			log.Debugf("skipping agent, socket is not set")
+			continue
+		}
+		socks = append(socks, s)
+	}
+	return
+}
+
 func (sc *SSHConfig) EnsureUser() {
 	// Like ssh(1), use $USER if no user specified
 	if sc.User == "" {
@@ -415,10 +796,15 @@ func (sc *SSHConfig) EnsureUser() {
 // It tries f as a private key first; if that fails, it tries f itself as a
 // public key (OpenSSH allows `IdentityFile foo.pub` when the private key lives
 // in ssh-agent), then a sibling f+".pub". Certificate public keys are
-// fingerprinted by their underlying key.
-func loadIdentity(f string) (signer ssh.Signer, fp string, ok bool) {
+// fingerprinted by their underlying key. Passphrase-protected keys are
+// decrypted on demand, see loadEncryptedKey.
+func (sc *SSHConfig) loadIdentity(f string) (signer ssh.Signer, fp string, ok bool) {
 	if s, err := loadPrivateKey(f); err == nil {
 		return s, keyFP(s.PublicKey()), true
+	} else if e, isEnc := passphraseMissing(err); isEnc {
+		if s := sc.loadEncryptedKey(f, e); s != nil {
+			return s, keyFP(s.PublicKey()), true
+		}
 	} else {
 		log.Debugf("private key %q could not be loaded: %v. "+
 			"Now trying as public key (including .pub sibling).", f, err)
@@ -446,7 +832,7 @@ func loadPrivateKey(path string) (ssh.Signer, error) {
 	}
 	signer, err := ssh.ParsePrivateKey(key)
 	if err != nil {
-		return nil, fmt.Errorf("could not parse key: %v", err)
+		return nil, fmt.Errorf("could not parse key: %w", err)
 	}
 	return signer, nil
 }
@@ -482,6 +868,22 @@ func loadCert(path string) (*ssh.Certificate, error) {
 	return cert, nil
 }
 
+// certExpiry returns the earliest expiry of the certificates among sigs,
+// along with the fingerprint of the key certified by the certificate expiring
+// then, or the zero time if none of them expires
+func certExpiry(sigs []ssh.Signer) (exp time.Time, key string) {
+	for _, s := range sigs {
+		c, ok := s.PublicKey().(*ssh.Certificate)
+		if !ok || c.ValidBefore == ssh.CertTimeInfinity {
+			continue
+		}
+		if t := time.Unix(int64(c.ValidBefore), 0); exp.IsZero() || t.Before(exp) {
+			exp, key = t, keyFP(c.Key)
+		}
+	}
+	return
+}
+
 func certify(cert *ssh.Certificate, sig ssh.Signer) (ssh.Signer, error) {
 	if _, ok := sig.PublicKey().(*ssh.Certificate); ok {
 		return nil, fmt.Errorf("signer is already a certificate identity")
@@ -529,6 +931,16 @@ func filter(alist, allowed []string) []string {
 	return out
 }
 
+// withoutCerts removes certificate algorithms from a list of algorithms
+func withoutCerts(algs []string) (out []string) {
+	for _, a := range algs {
+		if !strings.Contains(a, "-cert-v01@openssh.com") {
+			out = append(out, a)
+		}
+	}
+	return
+}
+
 // keyFP returns a fingerprint string for a public key
 // we can make this more sophisticated later if needed
 func keyFP(k ssh.PublicKey) string {
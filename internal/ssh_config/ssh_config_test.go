@@ -6,7 +6,9 @@ import (
 	"encoding/pem"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 )
@@ -40,7 +42,7 @@ func writeKeyPair(t *testing.T, dir, name string) (privPath, pubPath string) {
 func TestLoadIdentityPrivateKey(t *testing.T) {
 	priv, _ := writeKeyPair(t, t.TempDir(), "id_test")
 
-	s, fp, ok := loadIdentity(priv)
+	s, fp, ok := (&SSHConfig{}).loadIdentity(priv)
 	if !ok {
 		t.Fatal("expected loadIdentity to succeed")
 	}
@@ -64,7 +66,7 @@ func TestLoadIdentityPublicKeyOnly(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	s, fp, ok := loadIdentity(pub)
+	s, fp, ok := (&SSHConfig{}).loadIdentity(pub)
 	if !ok {
 		t.Fatal("expected loadIdentity to succeed for .pub-only IdentityFile")
 	}
@@ -81,11 +83,11 @@ func TestLoadIdentityPublicKeyOnly(t *testing.T) {
 func TestLoadIdentityFingerprintsMatch(t *testing.T) {
 	priv, pub := writeKeyPair(t, t.TempDir(), "id_test")
 
-	_, fpPriv, ok := loadIdentity(priv)
+	_, fpPriv, ok := (&SSHConfig{}).loadIdentity(priv)
 	if !ok {
 		t.Fatal("private-key load failed")
 	}
-	_, fpPub, ok := loadIdentity(pub)
+	_, fpPub, ok := (&SSHConfig{}).loadIdentity(pub)
 	if !ok {
 		t.Fatal("public-key load failed")
 	}
@@ -107,7 +109,7 @@ func TestLoadIdentitySiblingPublicKey(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	s, fp, ok := loadIdentity(priv)
+	s, fp, ok := (&SSHConfig{}).loadIdentity(priv)
 	if !ok {
 		t.Fatal("expected sibling .pub to be loaded")
 	}
@@ -120,8 +122,142 @@ func TestLoadIdentitySiblingPublicKey(t *testing.T) {
 }
 
 func TestLoadIdentityMissing(t *testing.T) {
-	s, fp, ok := loadIdentity(filepath.Join(t.TempDir(), "does-not-exist"))
+	s, fp, ok := (&SSHConfig{}).loadIdentity(filepath.Join(t.TempDir(), "does-not-exist"))
 	if ok || s != nil || fp != "" {
 		t.Fatalf("expected failure, got s=%v fp=%q ok=%v", s, fp, ok)
 	}
 }
+
+func TestProxyCommand(t *testing.T) {
+	sc := &SSHConfig{
+		Alias:        "alias",
+		HostName:     "example.com",
+		Port:         2222,
+		User:         "alice",
+		ProxyCommand: "nc -X connect -x proxy:8080 %h %p # %r %n %%",
+	}
+	want := "nc -X connect -x proxy:8080 example.com 2222 # alice alias %"
+	if got := sc.proxyCommand(); got != want {
+		t.Errorf("incorrect proxy command: %q", got)
+	}
+	if got := (&SSHConfig{}).proxyCommand(); got != "" {
+		t.Errorf("expected empty proxy command, got %q", got)
+	}
+}
+
+func TestParseSSHConfigTimeouts(t *testing.T) {
+	orig := overrideConfig
+	t.Cleanup(func() { overrideConfig = orig })
+	overrideConfig = filepath.Join(t.TempDir(), "config")
+	conf := `Host a
+    ServerAliveInterval 15
+    ServerAliveCountMax 5
+    ConnectTimeout 90
+    ConnectionAttempts 3
+`
+	if err := os.WriteFile(overrideConfig, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	sc, err := ParseSSHConfig("a", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.ServerAliveInterval != 15*time.Second || sc.ServerAliveCountMax != 5 {
+		t.Errorf("incorrect server alive options: %v, %v",
+			sc.ServerAliveInterval, sc.ServerAliveCountMax)
+	}
+	if sc.ConnectTimeout != 90*time.Second || sc.ConnectionAttempts != 3 {
+		t.Errorf("incorrect connect options: %v, %v", sc.ConnectTimeout, sc.ConnectionAttempts)
+	}
+
+	// Defaults of ssh(1)
+	sc, err = ParseSSHConfig("c", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.ServerAliveInterval != 0 || sc.ServerAliveCountMax != 3 ||
+		sc.ConnectTimeout != 0 || sc.ConnectionAttempts != 1 {
+		t.Errorf("incorrect defaults: %+v", sc)
+	}
+}
+
+func TestResolveIdentityAgents(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "/tmp/default.sock")
+	t.Setenv("OTHER_SOCK", "/tmp/other.sock")
+
+	cases := []struct {
+		specs []string
+		want  []string
+	}{
+		{[]string{""}, []string{"/tmp/default.sock"}},
+		{[]string{"SSH_AUTH_SOCK"}, []string{"/tmp/default.sock"}},
+		{[]string{"$OTHER_SOCK", "~/agent.sock"}, []string{"/tmp/other.sock", "~/agent.sock"}},
+		{[]string{"${OTHER_SOCK}", "none"}, nil},
+		{[]string{"$UNSET_SOCK"}, nil},
+	}
+	for _, c := range cases {
+		got := ResolveIdentityAgents(c.specs)
+		if strings.Join(got, ",") != strings.Join(c.want, ",") {
+			t.Errorf("ResolveIdentityAgents(%q) = %q, want %q", c.specs, got, c.want)
+		}
+	}
+}
+
+func TestCertExpiry(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certSigner := func(validBefore uint64) ssh.Signer {
+		c := &ssh.Certificate{Key: key.PublicKey(), CertType: ssh.UserCert,
+			ValidBefore: validBefore}
+		if err := c.SignCert(rand.Reader, key); err != nil {
+			t.Fatal(err)
+		}
+		s, err := ssh.NewCertSigner(c, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	if exp, _ := certExpiry([]ssh.Signer{key, certSigner(ssh.CertTimeInfinity)}); !exp.IsZero() {
+		t.Errorf("expected no expiry, got %v", exp)
+	}
+	soon := time.Now().Add(time.Hour).Truncate(time.Second)
+	later := soon.Add(time.Hour)
+	sigs := []ssh.Signer{certSigner(uint64(later.Unix())), key, certSigner(uint64(soon.Unix()))}
+	if exp, fp := certExpiry(sigs); !exp.Equal(soon) {
+		t.Errorf("incorrect expiry %v, want %v", exp, soon)
+	} else if fp != keyFP(key.PublicKey()) {
+		t.Errorf("incorrect certified key %v", fp)
+	}
+}
+
+func TestSettingsID(t *testing.T) {
+	k := edPub(t)
+	pins, err := ParseHostKeyPins(nil, []string{ssh.FingerprintSHA256(k)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := SSHConfig{IdentityFiles: []string{"~/.ssh/id_ed25519"}}
+	same := base
+	if base.settingsID() != same.settingsID() {
+		t.Error("equal settings have different IDs")
+	}
+	for name, sc := range map[string]SSHConfig{
+		"pins":     {IdentityFiles: base.IdentityFiles, Pins: pins},
+		"identity": {IdentityFiles: []string{"~/.ssh/id_rsa"}},
+		"agent":    {IdentityFiles: base.IdentityFiles, IdentityAgents: []string{"/tmp/agent.sock"}},
+		"trust":    {IdentityFiles: base.IdentityFiles, Trust: func(string, ssh.PublicKey, bool) bool { return true }},
+	} {
+		if sc.settingsID() == base.settingsID() {
+			t.Errorf("differing %v not reflected in ID", name)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/ssh_config"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	DefaultCertRenewBefore = 10 * time.Minute
+	certRenewTimeout       = 2 * time.Minute
+	certRenewRetry         = time.Minute
+)
+
+// renewals holds the renewal commands currently running, keyed by command
+// and certified key, such that tunnels sharing an identity renew it once
+var renewals = struct {
+	sync.Mutex
+	running map[string]*renewal
+}{running: make(map[string]*renewal)}
+
+type renewal struct {
+	done chan struct{}
+	err  error
+}
+
+// routeCertExpiry returns the earliest expiry of the certificates used for
+// any of the hops, along with the fingerprint of the key certified by the
+// certificate expiring then, or the zero time if none expires
+func routeCertExpiry(hops []ssh_config.Hop) (exp time.Time, key string) {
+	for _, h := range hops {
+		if !h.CertExpiry.IsZero() && (exp.IsZero() || h.CertExpiry.Before(exp)) {
+			exp, key = h.CertExpiry, h.CertKey
+		}
+	}
+	return
+}
+
+// renewCert runs the renewal command once the certificate used by the client
+// is about to expire. If the certificate was renewed, the client is closed,
+// which triggers the reconnection logic, picking up the new certificate.
+// Failed renewals are retried until the certificate expired. Tunnels using
+// the same certificate and command run the command only once.
+func (t *Tunnel) renewCert(c *ssh.Client, cancel chan struct{}) {
+	t.mu.Lock()
+	expiry, key, host := t.CertExpiry, t.certKey, t.ActiveHost
+	t.mu.Unlock()
+	if expiry.IsZero() {
+		return
+	}
+
+	// Renewal commands are aborted once the client or tunnel closes
+	ctx, abort := context.WithCancel(context.Background())
+	defer abort()
+	go func() {
+		select {
+		case <-cancel:
+		case <-t.stop:
+		case <-ctx.Done():
+		}
+		abort()
+	}()
+
+	timer := time.NewTimer(time.Until(expiry.Add(-t.CertRenewIn())))
+	defer timer.Stop()
+	for {
+		select {
+		case <-cancel:
+			return
+		case <-t.stop:
+			return
+		case <-timer.C:
+		}
+
+		if t.CertRenewCommand == "" {
+			log.Warningf("%v: certificate expires at %v, no renewal command set",
+				t.Name, expiry.Format(time.DateTime))
+			return
+		}
+		// The certificate may have been renewed by another tunnel already
+		if hops, err := t.resolveHops(host); err == nil {
+			if exp, _ := routeCertExpiry(hops); exp.After(expiry) {
+				log.Infof("%v: certificate already renewed until %v, re-connecting",
+					t.Name, exp.Format(time.DateTime))
+				c.Close()
+				return
+			}
+		}
+
+		log.Infof("%v: certificate expires at %v, renewing...", t.Name,
+			expiry.Format(time.DateTime))
+		id := t.CertRenewCommand + "\x00" + key
+		if err := renewOnce(ctx, id, t.CertRenewCommand); err != nil {
+			log.Errorf("%v: could not renew certificate: %v", t.Name, err)
+		} else if hops, err := t.resolveHops(host); err != nil {
+			log.Errorf("%v: could not load renewed certificate: %v", t.Name, err)
+		} else if exp, _ := routeCertExpiry(hops); !exp.After(expiry) {
+			log.Errorf("%v: renewal command did not renew the certificate", t.Name)
+		} else {
+			log.Infof("%v: certificate renewed until %v, re-connecting", t.Name,
+				exp.Format(time.DateTime))
+			c.Close()
+			return
+		}
+
+		if left := time.Until(expiry); left > 0 {
+			timer.Reset(min(certRenewRetry, left))
+			continue
+		}
+		log.Errorf("%v: certificate expired", t.Name)
+		return
+	}
+}
+
+// CertRenewIn returns how long before expiry certificates are renewed
+func (d *Desc) CertRenewIn() time.Duration {
+	return durationOr(d.CertRenewBefore, DefaultCertRenewBefore)
+}
+
+// renewOnce runs the renewal command unless it is already running for key,
+// in which case it waits for and returns the result of the running command
+func renewOnce(ctx context.Context, key, command string) error {
+	renewals.Lock()
+	if r, ok := renewals.running[key]; ok {
+		renewals.Unlock()
+		select {
+		case <-r.done:
+			return r.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	r := &renewal{done: make(chan struct{})}
+	renewals.running[key] = r
+	renewals.Unlock()
+
+	r.err = runRenewCommand(ctx, command)
+	renewals.Lock()
+	delete(renewals.running, key)
+	renewals.Unlock()
+	close(r.done)
+	return r.err
+}
+
+// runRenewCommand runs a certificate renewal command through a shell
+func runRenewCommand(ctx context.Context, command string) error {
+	ctx, cancel := context.WithTimeout(ctx, certRenewTimeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if s := strings.TrimSpace(string(out)); s != "" {
+			return fmt.Errorf("%v: %v", err, s)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package tunnel
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alebeck/boring/internal/ssh_config"
+)
+
+func TestRouteCertExpiry(t *testing.T) {
+	soon := time.Now().Add(time.Hour)
+	hops := []ssh_config.Hop{
+		{CertExpiry: soon.Add(time.Hour)},
+		{},
+		{CertExpiry: soon, CertKey: "SHA256:soon"},
+	}
+	if exp, key := routeCertExpiry(hops); !exp.Equal(soon) || key != "SHA256:soon" {
+		t.Errorf("incorrect expiry %v of %v, want %v", exp, key, soon)
+	}
+	if exp, _ := routeCertExpiry([]ssh_config.Hop{{}}); !exp.IsZero() {
+		t.Errorf("expected no expiry, got %v", exp)
+	}
+}
+
+func TestRunRenewCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	if err := runRenewCommand(context.Background(), "true"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err := runRenewCommand(context.Background(), "echo no token >&2; exit 1")
+	if err == nil || !strings.Contains(err.Error(), "no token") {
+		t.Errorf("expected error with output, got %v", err)
+	}
+}
+
+func TestRenewOnce(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	runs := filepath.Join(t.TempDir(), "runs")
+	cmd := "sleep 0.5; echo >> " + runs
+
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := renewOnce(context.Background(), "id", cmd); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	b, err := os.ReadFile(runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(b), "\n"); n != 1 {
+		t.Errorf("command ran %d times, want once", n)
+	}
+
+	if err := renewOnce(context.Background(), "id", cmd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b, _ = os.ReadFile(runs); strings.Count(string(b), "\n") != 2 {
+		t.Errorf("finished command was not run again")
+	}
+}
@@ -0,0 +1,59 @@
+package tunnel
+
+import (
+	"slices"
+
+	"github.com/alebeck/boring/internal/log"
+)
+
+// Dependencies returns the names of the tunnels which need to be running
+// for the tunnel to be opened, i.e., the ones it depends on and the one
+// it connects through.
+func (d *Desc) Dependencies() []string {
+	var ns []string
+	if d.ViaTunnel != "" {
+		ns = append(ns, d.ViaTunnel)
+	}
+	for _, n := range d.DependsOn {
+		if !slices.Contains(ns, n) {
+			ns = append(ns, n)
+		}
+	}
+	return ns
+}
+
+// DependsOnTunnel reports whether the tunnel of the given name needs to
+// be running for the tunnel to be opened, see Dependencies
+func (d *Desc) DependsOnTunnel(name string) bool {
+	return d.ViaTunnel == name || slices.Contains(d.DependsOn, name)
+}
+
+// SetOnReconnect sets a function which is called after the tunnel
+// re-connected, e.g., to re-connect the tunnels depending on it.
+func (t *Tunnel) SetOnReconnect(f func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onReconnect = f
+}
+
+func (t *Tunnel) reconnected() {
+	t.mu.Lock()
+	f := t.onReconnect
+	t.mu.Unlock()
+	if f != nil {
+		go f()
+	}
+}
+
+// ForceReconnect makes a connected tunnel re-connect. Its client is only
+// released rather than closed, so tunnels sharing it are not affected.
+func (t *Tunnel) ForceReconnect() {
+	t.mu.Lock()
+	disconnect := t.disconnect
+	t.mu.Unlock()
+	if disconnect == nil {
+		return
+	}
+	log.Infof("%v: re-connecting", t.Name)
+	disconnect()
+}
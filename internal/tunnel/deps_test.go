@@ -0,0 +1,19 @@
+package tunnel
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDependencies(t *testing.T) {
+	d := &Desc{ViaTunnel: "a", DependsOn: []string{"b", "a", "c"}}
+	if ds := d.Dependencies(); !reflect.DeepEqual(ds, []string{"a", "b", "c"}) {
+		t.Errorf("incorrect dependencies: %v", ds)
+	}
+	if !d.DependsOnTunnel("a") || !d.DependsOnTunnel("c") || d.DependsOnTunnel("d") {
+		t.Errorf("incorrect dependency check")
+	}
+	if ds := (&Desc{}).Dependencies(); len(ds) != 0 {
+		t.Errorf("expected no dependencies: %v", ds)
+	}
+}
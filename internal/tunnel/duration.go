@@ -0,0 +1,67 @@
+package tunnel
+
+import (
+	"fmt"
+	"time"
+)
+
+// Duration is a time.Duration which can be given as a Go duration string,
+// e.g. "1h30m", or as an integer number of minutes in the TOML config.
+type Duration time.Duration
+
+// Forever is an unlimited duration, given as "forever" in the config
+const Forever Duration = -1
+
+func (d *Duration) UnmarshalTOML(v any) error {
+	switch value := v.(type) {
+	case int64:
+		*d = Duration(time.Duration(value) * time.Minute)
+	case string:
+		if value == "forever" {
+			*d = Forever
+			return nil
+		}
+		p, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = Duration(p)
+	default:
+		return fmt.Errorf("unsupported type: %T", v)
+	}
+	if *d < 0 {
+		return fmt.Errorf("negative duration: %v", d)
+	}
+	return nil
+}
+
+// Timeout is a time.Duration which can be given as a Go duration string,
+// e.g. "20s", or as an integer number of seconds in the TOML config.
+// Unlike Duration, it cannot be forever.
+type Timeout time.Duration
+
+func (d *Timeout) UnmarshalTOML(v any) error {
+	switch value := v.(type) {
+	case int64:
+		*d = Timeout(time.Duration(value) * time.Second)
+	case string:
+		p, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = Timeout(p)
+	default:
+		return fmt.Errorf("unsupported type: %T", v)
+	}
+	if *d < 0 {
+		return fmt.Errorf("negative timeout: %v", time.Duration(*d))
+	}
+	return nil
+}
+
+func (d Duration) String() string {
+	if d == Forever {
+		return "forever"
+	}
+	return time.Duration(d).String()
+}
@@ -0,0 +1,59 @@
+package tunnel
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDurationUnmarshal(t *testing.T) {
+	var d Duration
+	if err := d.UnmarshalTOML("1h30m"); err != nil || time.Duration(d) != 90*time.Minute {
+		t.Errorf("incorrect duration %v: %v", d, err)
+	}
+	if err := d.UnmarshalTOML(int64(15)); err != nil || time.Duration(d) != 15*time.Minute {
+		t.Errorf("incorrect duration %v: %v", d, err)
+	}
+}
+
+func TestDurationUnmarshalInvalid(t *testing.T) {
+	var d Duration
+	if err := d.UnmarshalTOML("-5m"); err == nil ||
+		!strings.Contains(err.Error(), "negative duration") {
+		t.Errorf("incorrect error: %v", err)
+	}
+	if err := d.UnmarshalTOML(struct{}{}); err == nil ||
+		!strings.Contains(err.Error(), "unsupported type") {
+		t.Errorf("incorrect error: %v", err)
+	}
+}
+
+func TestDurationForever(t *testing.T) {
+	var d Duration
+	if err := d.UnmarshalTOML("forever"); err != nil || d != Forever {
+		t.Errorf("incorrect duration %v: %v", d, err)
+	}
+	if d.String() != "forever" {
+		t.Errorf("incorrect string: %v", d.String())
+	}
+}
+
+func TestTimeoutUnmarshal(t *testing.T) {
+	var d Timeout
+	if err := d.UnmarshalTOML("20s"); err != nil || time.Duration(d) != 20*time.Second {
+		t.Errorf("incorrect timeout %v: %v", d, err)
+	}
+	if err := d.UnmarshalTOML(int64(15)); err != nil || time.Duration(d) != 15*time.Second {
+		t.Errorf("incorrect timeout %v: %v", d, err)
+	}
+	if err := d.UnmarshalTOML("forever"); err == nil {
+		t.Error("expected error for forever")
+	}
+	if err := d.UnmarshalTOML("-5s"); err == nil ||
+		!strings.Contains(err.Error(), "negative timeout") {
+		t.Errorf("incorrect error: %v", err)
+	}
+	if err := d.UnmarshalTOML(int64(-5)); err == nil {
+		t.Error("expected error for negative seconds")
+	}
+}
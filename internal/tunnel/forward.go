@@ -0,0 +1,85 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Forward describes a single port forwarding. A tunnel carries one or more
+// forwards, which all share the tunnel's SSH connection.
+type Forward struct {
+	LocalAddress  StringOrInt `toml:"local" json:"local"`
+	RemoteAddress StringOrInt `toml:"remote" json:"remote"`
+	Mode          Mode        `toml:"mode" json:"mode"`
+	// Bound is the address actually bound by the listener of a running
+	// forward, which differs from the configured one for port 0.
+	Bound string `toml:"-" json:"bound,omitempty"`
+}
+
+func (f Forward) String() string {
+	return fmt.Sprintf("%v %v %v", f.LocalAddress, f.Mode, f.RemoteAddress)
+}
+
+func (f Forward) isRemote() bool {
+	return f.Mode == Remote || f.Mode == RemoteSocks
+}
+
+// AllForwards returns the forwards of a tunnel in order, starting
+// with the one specified at the top level of the tunnel, if any.
+func (d *Desc) AllForwards() []Forward {
+	var fs []Forward
+	if d.LocalAddress != "" || d.RemoteAddress != "" {
+		fs = append(fs, d.Forward)
+	}
+	return append(fs, d.Forwards...)
+}
+
+// forwardRefs is like AllForwards, but refers to the forwards of d
+func (d *Desc) forwardRefs() []*Forward {
+	var fs []*Forward
+	if d.LocalAddress != "" || d.RemoteAddress != "" {
+		fs = append(fs, &d.Forward)
+	}
+	for i := range d.Forwards {
+		fs = append(fs, &d.Forwards[i])
+	}
+	return fs
+}
+
+// Ephemeral tells whether the listening side of f binds port 0
+func (f Forward) Ephemeral() bool {
+	addr := string(f.LocalAddress)
+	if f.isRemote() {
+		addr = string(f.RemoteAddress)
+	}
+	return addr == "0" || strings.HasSuffix(addr, ":0")
+}
+
+// forward is the runtime state of a Forward within a running tunnel.
+type forward struct {
+	Forward
+	// desc is the described forward, used for reporting the bound address
+	desc       *Forward
+	listener   net.Listener
+	localAddr  *address
+	remoteAddr *address
+}
+
+func makeForward(f *Forward) (*forward, error) {
+	var err error
+	fw := &forward{Forward: *f, desc: f}
+
+	allowShort := f.isRemote()
+	fw.remoteAddr, err = parseAddr(string(f.RemoteAddress), allowShort)
+	if err != nil {
+		return nil, fmt.Errorf("remote address: %v", err)
+	}
+
+	fw.localAddr, err = parseAddr(string(f.LocalAddress), !allowShort)
+	if err != nil {
+		return nil, fmt.Errorf("local address: %v", err)
+	}
+
+	return fw, nil
+}
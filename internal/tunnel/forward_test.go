@@ -0,0 +1,52 @@
+package tunnel
+
+import "testing"
+
+func TestAllForwards(t *testing.T) {
+	d := &Desc{
+		Forward:  Forward{LocalAddress: "9000", RemoteAddress: "localhost:9000"},
+		Forwards: []Forward{{LocalAddress: "9001", Mode: Socks}},
+	}
+	fs := d.AllForwards()
+	if len(fs) != 2 || fs[0].LocalAddress != "9000" || fs[1].Mode != Socks {
+		t.Errorf("incorrect forwards: %v", fs)
+	}
+}
+
+func TestAllForwardsNoTopLevel(t *testing.T) {
+	d := &Desc{Forwards: []Forward{{LocalAddress: "9001", RemoteAddress: "localhost:9001"}}}
+	fs := d.AllForwards()
+	if len(fs) != 1 || fs[0].LocalAddress != "9001" {
+		t.Errorf("incorrect forwards: %v", fs)
+	}
+}
+
+func TestForwardEphemeral(t *testing.T) {
+	for _, c := range []struct {
+		f    Forward
+		want bool
+	}{
+		{Forward{LocalAddress: "0", RemoteAddress: "localhost:80"}, true},
+		{Forward{LocalAddress: "127.0.0.1:0", Mode: Socks}, true},
+		{Forward{LocalAddress: "8080", RemoteAddress: "0", Mode: Remote}, true},
+		{Forward{LocalAddress: "0", RemoteAddress: "localhost:80", Mode: Remote}, false},
+		{Forward{LocalAddress: "8000", RemoteAddress: "localhost:80"}, false},
+	} {
+		if got := c.f.Ephemeral(); got != c.want {
+			t.Errorf("Ephemeral(%v) = %v, want %v", c.f, got, c.want)
+		}
+	}
+}
+
+func TestForwardRefs(t *testing.T) {
+	d := &Desc{
+		Forward:  Forward{LocalAddress: "0", RemoteAddress: "localhost:9000"},
+		Forwards: []Forward{{LocalAddress: "0", Mode: Socks}},
+	}
+	for _, f := range d.forwardRefs() {
+		f.Bound = "127.0.0.1:1234"
+	}
+	if d.Bound != "127.0.0.1:1234" || d.Forwards[0].Bound != "127.0.0.1:1234" {
+		t.Errorf("bound addresses not set: %+v", d)
+	}
+}
@@ -0,0 +1,213 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/alebeck/boring/internal/log"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultHealthInterval = 30 * time.Second
+	defaultHealthTimeout  = 5 * time.Second
+	maxExpectRead         = 64 * 1024
+)
+
+// HealthCheck describes a check of the service behind a tunnel, which is
+// run periodically through the tunnel's SSH connection.
+type HealthCheck struct {
+	// Type is either "tcp" (default), "http" or "send"
+	Type string `toml:"type" json:"type"`
+	// Address is the address to check on the remote side, it defaults
+	// to the remote address of the first local forward
+	Address StringOrInt `toml:"address" json:"address"`
+	// Path and Status are the URL path and expected status of http checks
+	Path   string `toml:"path" json:"path"`
+	Status int    `toml:"status" json:"status"`
+	// Send is written to the connection in send checks, after which
+	// Expect must be read from it
+	Send   string `toml:"send" json:"send"`
+	Expect string `toml:"expect" json:"expect"`
+	// Interval is the time between checks and Timeout the time a single
+	// check may take, integers are seconds
+	Interval Timeout `toml:"interval" json:"interval"`
+	Timeout  Timeout `toml:"timeout" json:"timeout"`
+	// Failures is the number of consecutive failed checks after which
+	// the tunnel is considered degraded
+	Failures int `toml:"failures" json:"failures"`
+	// Reconnect re-connects the tunnel once it is degraded
+	Reconnect bool `toml:"reconnect" json:"reconnect"`
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// makeHealthAddr validates the health check of a tunnel and resolves
+// the address to check.
+func (t *Tunnel) makeHealthAddr() (*address, error) {
+	h := t.Health
+	switch h.Type {
+	case "", "tcp", "http":
+	case "send":
+		if h.Send == "" && h.Expect == "" {
+			return nil, fmt.Errorf("send health checks require 'send' or 'expect'")
+		}
+	default:
+		return nil, fmt.Errorf("unknown health check type '%v'", h.Type)
+	}
+
+	if h.Address != "" {
+		return parseAddr(string(h.Address), true)
+	}
+	for _, f := range t.forwards {
+		if f.Mode == Local {
+			return f.remoteAddr, nil
+		}
+	}
+	return nil, fmt.Errorf("health check requires 'address' when the tunnel has no local forwards")
+}
+
+func (h *HealthCheck) check(ctx context.Context, a *address, dial dialFunc) error {
+	switch h.Type {
+	case "http":
+		return h.checkHTTP(ctx, a, dial)
+	case "send":
+		return h.checkSend(ctx, a, dial)
+	}
+	conn, err := dial(ctx, a.net, a.addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (h *HealthCheck) checkHTTP(ctx context.Context, a *address, dial dialFunc) error {
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dial(ctx, a.net, a.addr)
+		},
+		DisableKeepAlives: true,
+	}}
+
+	host := a.addr
+	if a.net == "unix" {
+		host = "localhost"
+	}
+	path := h.Path
+	if path == "" || path[0] != '/' {
+		path = "/" + path
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	want := h.Status
+	if want == 0 {
+		want = http.StatusOK
+	}
+	if resp.StatusCode != want {
+		return fmt.Errorf("expected status %d, got %d", want, resp.StatusCode)
+	}
+	return nil
+}
+
+func (h *HealthCheck) checkSend(ctx context.Context, a *address, dial dialFunc) error {
+	conn, err := dial(ctx, a.net, a.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(dl)
+	}
+
+	if h.Send != "" {
+		if _, err = io.WriteString(conn, h.Send); err != nil {
+			return err
+		}
+	}
+	if h.Expect == "" {
+		return nil
+	}
+
+	var buf []byte
+	b := make([]byte, 4096)
+	for len(buf) < maxExpectRead {
+		n, err := conn.Read(b)
+		buf = append(buf, b[:n]...)
+		if bytes.Contains(buf, []byte(h.Expect)) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("expected %q: %v", h.Expect, err)
+		}
+	}
+	return fmt.Errorf("expected %q, not found in response", h.Expect)
+}
+
+// healthCheck periodically checks the service behind the tunnel through
+// client c, marking the tunnel as degraded if it fails.
+func (t *Tunnel) healthCheck(c *ssh.Client, cancel chan struct{}) {
+	h := t.Health
+	interval := time.Duration(h.Interval)
+	if interval <= 0 {
+		interval = defaultHealthInterval
+	}
+	timeout := time.Duration(h.Timeout)
+	if timeout <= 0 {
+		timeout = defaultHealthTimeout
+	}
+
+	failures := 0
+	next := time.NewTimer(0)
+	defer next.Stop()
+	for {
+		select {
+		case <-cancel:
+			return
+		case <-t.stop:
+			return
+		case <-next.C:
+		}
+
+		ctx, cancelCtx := context.WithTimeout(context.Background(), timeout)
+		err := h.check(ctx, t.healthAddr, c.DialContext)
+		cancelCtx()
+		next.Reset(interval)
+
+		if err == nil {
+			failures = 0
+			t.mu.Lock()
+			if t.Status == Degraded {
+				log.Infof("%v: health check passed again", t.Name)
+				t.Status = Open
+				t.LastError = ""
+			}
+			t.mu.Unlock()
+			continue
+		}
+
+		failures++
+		log.Warningf("%v: health check failed: %v", t.Name, err)
+		if failures < max(h.Failures, 1) || t.State() == Degraded {
+			continue
+		}
+		t.setFailed(Degraded, fmt.Sprintf("health check failed: %v", err))
+		if h.Reconnect {
+			// Only this tunnel re-connects, the client may be shared
+			t.ForceReconnect()
+			return
+		}
+	}
+}
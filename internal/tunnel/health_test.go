@@ -0,0 +1,100 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testDial(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
+
+func testCheck(h *HealthCheck, addr string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return h.check(ctx, &address{addr, "tcp"}, testDial)
+}
+
+func TestHealthHTTP(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+	addr := strings.TrimPrefix(s.URL, "http://")
+
+	if err := testCheck(&HealthCheck{Type: "http", Path: "health"}, addr); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := testCheck(&HealthCheck{Type: "http"}, addr); err == nil ||
+		!strings.Contains(err.Error(), "expected status 200, got 404") {
+		t.Errorf("incorrect error: %v", err)
+	}
+	if err := testCheck(&HealthCheck{Type: "http", Status: 404}, addr); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestHealthSend(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 4)
+			c.Read(buf)
+			if string(buf) == "PING" {
+				c.Write([]byte("+PONG\r\n"))
+			}
+			c.Close()
+		}
+	}()
+	addr := l.Addr().String()
+
+	if err := testCheck(&HealthCheck{Type: "send", Send: "PING", Expect: "PONG"}, addr); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := testCheck(&HealthCheck{Type: "send", Send: "PINK", Expect: "PONG"}, addr); err == nil {
+		t.Errorf("expected error")
+	}
+	if err := testCheck(&HealthCheck{}, addr); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestHealthAddr(t *testing.T) {
+	tun := FromDesc(&Desc{Health: &HealthCheck{}})
+	tun.forwards = []*forward{
+		{Forward: Forward{Mode: Remote}, remoteAddr: &address{"localhost:1", "tcp"}},
+		{Forward: Forward{Mode: Local}, remoteAddr: &address{"localhost:2", "tcp"}},
+	}
+	if a, err := tun.makeHealthAddr(); err != nil || a.addr != "localhost:2" {
+		t.Errorf("incorrect address %v: %v", a, err)
+	}
+	tun.Health = &HealthCheck{Address: "3"}
+	if a, err := tun.makeHealthAddr(); err != nil || a.addr != "localhost:3" {
+		t.Errorf("incorrect address %v: %v", a, err)
+	}
+	tun.Health = &HealthCheck{}
+	tun.forwards = tun.forwards[:1]
+	if _, err := tun.makeHealthAddr(); err == nil || !strings.Contains(err.Error(), "'address'") {
+		t.Errorf("expected error naming the address option, got %v", err)
+	}
+	tun.Health = &HealthCheck{Type: "ping"}
+	if _, err := tun.makeHealthAddr(); err == nil {
+		t.Errorf("expected error for unknown type")
+	}
+}
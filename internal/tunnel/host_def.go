@@ -0,0 +1,135 @@
+package tunnel
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/alebeck/boring/internal/ssh_config"
+)
+
+// HostDef defines a host in the boring config, such that it does not need
+// to be configured in ssh_config. Tunnels refer to hosts by their name.
+type HostDef struct {
+	Name     string `toml:"name" json:"name"`
+	HostName string `toml:"hostname" json:"hostname,omitempty"`
+	Port     int    `toml:"port" json:"port,omitempty"`
+	User     string `toml:"user" json:"user,omitempty"`
+	// IdentityFile lists the identity files to try, like IdentityFile
+	// in ssh_config. Keys of agents are tried as well.
+	IdentityFile StringOrList `toml:"identity" json:"identity,omitempty"`
+	// KnownHosts lists the known hosts files, new keys are added to the
+	// first one
+	KnownHosts StringOrList `toml:"known_hosts" json:"known_hosts,omitempty"`
+	// StrictHostKeyChecking takes the values of the ssh_config option
+	StrictHostKeyChecking string `toml:"strict_host_key_checking" json:"strict_host_key_checking,omitempty"`
+	// Jump lists the hosts to connect through in order, each given by
+	// the name of another host definition
+	Jump []string `toml:"jump" json:"jump,omitempty"`
+}
+
+// HostDefs maps the names of host definitions to the definitions
+type HostDefs map[string]HostDef
+
+// Validate checks the definitions, including that all jump hosts are
+// defined and that there are no cycles
+func (d HostDefs) Validate() error {
+	for name, h := range d {
+		switch h.StrictHostKeyChecking {
+		case "", "yes", "ask", "no", "off", "accept-new":
+		default:
+			return fmt.Errorf("host '%v': unsupported strict_host_key_checking '%v'",
+				name, h.StrictHostKeyChecking)
+		}
+		if _, err := d.Chain(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Chain returns the names of all hosts to connect through in order to
+// reach the given host, followed by the host itself. Jump hosts are
+// expanded recursively.
+func (d HostDefs) Chain(name string) ([]string, error) {
+	return d.chain(name, nil)
+}
+
+func (d HostDefs) chain(name string, path []string) ([]string, error) {
+	h, ok := d[name]
+	if !ok && len(path) == 0 {
+		return nil, fmt.Errorf("host '%v' is not defined", name)
+	} else if !ok {
+		return nil, fmt.Errorf("host '%v': jump host '%v' is not defined",
+			path[len(path)-1], name)
+	}
+	path = append(path, name)
+	var names []string
+	for _, j := range h.Jump {
+		if slices.Contains(path, j) {
+			return nil, fmt.Errorf("host '%v': jump hosts form a cycle: %v -> %v",
+				path[0], strings.Join(path, " -> "), j)
+		}
+		js, err := d.chain(j, path)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, js...)
+	}
+	return append(names, name), nil
+}
+
+// sshConfig converts the definition to an SSHConfig, using the defaults
+// of ssh_config for all other options
+func (h *HostDef) sshConfig() (*ssh_config.SSHConfig, error) {
+	sc, err := ssh_config.DefaultSSHConfig(h.Name)
+	if err != nil {
+		return nil, err
+	}
+	sc.HostName = h.HostName
+	if sc.HostName == "" {
+		sc.HostName = h.Name
+	}
+	if h.Port != 0 {
+		sc.Port = h.Port
+	}
+	sc.User = h.User
+	if len(h.IdentityFile) > 0 {
+		sc.IdentityFiles = h.IdentityFile
+	}
+	if len(h.KnownHosts) > 0 {
+		sc.KnownHostsFiles = h.KnownHosts
+		sc.UserKnownHostsFile = h.KnownHosts[0]
+	}
+	if h.StrictHostKeyChecking != "" {
+		if err := sc.SetStrictHostKeyChecking(h.StrictHostKeyChecking); err != nil {
+			return nil, err
+		}
+	}
+	return sc, nil
+}
+
+// definedHops makes the hops to a host defined in the boring config
+func (t *Tunnel) definedHops(host string) ([]ssh_config.Hop, error) {
+	chain, err := t.HostDefs.Chain(host)
+	if err != nil {
+		return nil, err
+	}
+	hops := make([]ssh_config.Hop, 0, len(chain))
+	for i, name := range chain {
+		def := t.HostDefs[name]
+		sc, err := def.sshConfig()
+		if err != nil {
+			return nil, fmt.Errorf("host '%v': %v", name, err)
+		}
+		if err := t.configure(sc, i == len(chain)-1); err != nil {
+			return nil, err
+		}
+		hop, err := sc.ToHop()
+		if err != nil {
+			return nil, err
+		}
+		hops = append(hops, hop)
+	}
+	return hops, nil
+}
@@ -0,0 +1,114 @@
+package tunnel
+
+import (
+	"crypto/ed25519"
+	"encoding/pem"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alebeck/boring/internal/log"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestHostDefsChain(t *testing.T) {
+	d := HostDefs{
+		"a": {Name: "a", Jump: []string{"b", "c"}},
+		"b": {Name: "b"},
+		"c": {Name: "c", Jump: []string{"d"}},
+		"d": {Name: "d"},
+	}
+	chain, err := d.Chain("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(chain, []string{"b", "d", "c", "a"}) {
+		t.Errorf("incorrect chain: %v", chain)
+	}
+	if err := d.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestHostDefsInvalid(t *testing.T) {
+	cases := []struct {
+		defs HostDefs
+		err  string
+	}{
+		{HostDefs{"a": {Name: "a", Jump: []string{"b"}}}, "not defined"},
+		{HostDefs{"a": {Name: "a", Jump: []string{"a"}}}, "cycle"},
+		{HostDefs{
+			"a": {Name: "a", Jump: []string{"b"}},
+			"b": {Name: "b", Jump: []string{"a"}},
+		}, "cycle"},
+		{HostDefs{"a": {Name: "a", StrictHostKeyChecking: "maybe"}}, "unsupported"},
+	}
+	for _, c := range cases {
+		err := c.defs.Validate()
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%v: expected error containing '%v', got %v", c.defs, c.err, err)
+		}
+	}
+}
+
+func TestDefinedHops(t *testing.T) {
+	log.Init(io.Discard, false, false)
+	key := writeKey(t)
+	tun := FromDesc(&Desc{
+		Host:          "target",
+		User:          "override",
+		IdentityAgent: StringOrList{"none"},
+		HostDefs: HostDefs{
+			"target": {
+				Name:                  "target",
+				HostName:              "10.0.0.2",
+				User:                  "alice",
+				IdentityFile:          StringOrList{key},
+				KnownHosts:            StringOrList{filepath.Join(t.TempDir(), "known_hosts")},
+				StrictHostKeyChecking: "accept-new",
+				Jump:                  []string{"bastion"},
+			},
+			"bastion": {
+				Name:                  "bastion",
+				HostName:              "bastion.example.com",
+				Port:                  2222,
+				User:                  "bob",
+				IdentityFile:          StringOrList{key},
+				StrictHostKeyChecking: "no",
+			},
+		},
+	})
+	hops, err := tun.resolveHops("target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hops) != 2 {
+		t.Fatalf("expected 2 hops, got %v", len(hops))
+	}
+	if h := hops[0]; h.HostName != "bastion.example.com" || h.Port != 2222 || h.User != "bob" {
+		t.Errorf("incorrect jump hop: %v:%v as %v", h.HostName, h.Port, h.User)
+	}
+	// Tunnel options only apply to the target
+	if h := hops[1]; h.HostName != "10.0.0.2" || h.Port != 22 || h.User != "override" {
+		t.Errorf("incorrect target hop: %v:%v as %v", h.HostName, h.Port, h.User)
+	}
+}
+
+func writeKey(t *testing.T) string {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
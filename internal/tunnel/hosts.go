@@ -0,0 +1,102 @@
+package tunnel
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/ssh_config"
+)
+
+const latencyTimeout = 5 * time.Second
+
+// route is a series of hops to one of the hosts of a tunnel
+type route struct {
+	host string
+	hops []ssh_config.Hop
+	// err is set if the hops could not be resolved
+	err error
+}
+
+// AllHosts returns the hosts of a tunnel in order, starting with the
+// one specified by the host option, if any.
+func (d *Desc) AllHosts() []string {
+	var hs []string
+	if d.Host != "" {
+		hs = append(hs, d.Host)
+	}
+	return append(hs, d.Hosts...)
+}
+
+// byLatency orders routes by the time it takes for the server of their
+// first hop to respond, see latency. Unreachable routes are put last,
+// keeping their order.
+func (t *Tunnel) byLatency(routes []route) []route {
+	lat := make([]time.Duration, len(routes))
+	var wg sync.WaitGroup
+	for i, r := range routes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lat[i] = math.MaxInt64
+			if len(r.hops) == 0 {
+				return
+			}
+			l, err := t.latency(r.hops[0])
+			if err != nil {
+				log.Debugf("%v: could not reach %v: %v", t.Name, r.host, err)
+				return
+			}
+			lat[i] = l
+		}()
+	}
+	wg.Wait()
+
+	idx := make([]int, len(routes))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool { return lat[idx[a]] < lat[idx[b]] })
+	sorted := make([]route, len(routes))
+	for i, j := range idx {
+		sorted[i] = routes[j]
+	}
+	return sorted
+}
+
+// latency connects to hop like dialHops does, e.g., through a proxy, and
+// returns the time until the server sent its first bytes. Just connecting
+// would not tell the latency when connecting through a proxy.
+func (t *Tunnel) latency(hop ssh_config.Hop) (time.Duration, error) {
+	start := time.Now()
+	dial, err := t.firstDial(hop)
+	if err != nil {
+		return 0, err
+	}
+	addr := fmt.Sprintf("%v:%v", hop.HostName, hop.Port)
+	var conn net.Conn
+	switch {
+	case dial != nil:
+		conn, err = dial("tcp", addr)
+	case hop.ProxyCommand != "":
+		conn, err = dialCommand(hop.ProxyCommand, addr)
+	default:
+		conn, err = net.DialTimeout("tcp", addr, latencyTimeout)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	// Not all connections support deadlines, e.g., of proxy commands
+	timeout := time.AfterFunc(latencyTimeout, func() { conn.Close() })
+	defer timeout.Stop()
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
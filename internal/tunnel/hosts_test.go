@@ -0,0 +1,87 @@
+package tunnel
+
+import (
+	"io"
+	"net"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/ssh_config"
+)
+
+func TestAllHosts(t *testing.T) {
+	d := &Desc{Host: "a", Hosts: []string{"b", "c"}}
+	if hs := d.AllHosts(); !reflect.DeepEqual(hs, []string{"a", "b", "c"}) {
+		t.Errorf("incorrect hosts: %v", hs)
+	}
+	d = &Desc{Hosts: []string{"b"}}
+	if hs := d.AllHosts(); !reflect.DeepEqual(hs, []string{"b"}) {
+		t.Errorf("incorrect hosts: %v", hs)
+	}
+}
+
+func TestByLatency(t *testing.T) {
+	log.Init(io.Discard, false, false)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Write([]byte("SSH-2.0-test\r\n"))
+			c.Close()
+		}
+	}()
+
+	// Get a port nobody is listening on
+	l2, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := l2.Addr().(*net.TCPAddr).Port
+	l2.Close()
+
+	routes := []route{
+		{host: "down", hops: []ssh_config.Hop{{HostName: "127.0.0.1", Port: closedPort}}},
+		{host: "none"},
+		{host: "up", hops: []ssh_config.Hop{{HostName: "127.0.0.1", Port: port}}},
+	}
+	var hosts []string
+	tun := &Tunnel{Desc: &Desc{Name: "test", Proxy: "none"}}
+	for _, r := range tun.byLatency(routes) {
+		hosts = append(hosts, r.host)
+	}
+	if !reflect.DeepEqual(hosts, []string{"up", "down", "none"}) {
+		t.Errorf("incorrect order: %v", hosts)
+	}
+}
+
+func TestByLatencyProxyCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	log.Init(io.Discard, false, false)
+
+	// The hop is only reachable through its proxy command
+	routes := []route{
+		{host: "down", hops: []ssh_config.Hop{{HostName: "127.0.0.1", Port: 1}}},
+		{host: "command", hops: []ssh_config.Hop{{HostName: "127.0.0.1", Port: 1,
+			ProxyCommand: "echo SSH-2.0-test"}}},
+	}
+	tun := &Tunnel{Desc: &Desc{Name: "test", Proxy: "none"}}
+	var hosts []string
+	for _, r := range tun.byLatency(routes) {
+		hosts = append(hosts, r.host)
+	}
+	if !reflect.DeepEqual(hosts, []string{"command", "down"}) {
+		t.Errorf("incorrect order: %v", hosts)
+	}
+}
@@ -0,0 +1,146 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/alebeck/boring/internal/log"
+	"golang.org/x/crypto/ssh"
+)
+
+const defaultLazyIdle = 5 * time.Minute
+
+// openLazy binds the listeners of a lazy tunnel without connecting to
+// the server. The client is only made once the first connection is accepted,
+// and dropped again after being idle for a while.
+func (t *Tunnel) openLazy() error {
+	for _, f := range t.forwards {
+		if f.isRemote() {
+			return fmt.Errorf("lazy tunnels only support local and socks forwards")
+		}
+	}
+
+	fs, err := t.makeListeners()
+	if err != nil {
+		return fmt.Errorf("cannot listen: %v", err)
+	}
+
+	t.stop = make(chan struct{})
+	t.Closed = make(chan struct{})
+
+	go t.runLazy(fs)
+
+	log.Infof("%v: opened lazy tunnel", t.Name)
+	t.setStatus(Idle)
+	return nil
+}
+
+func (t *Tunnel) runLazy(fs map[*forward]net.Listener) {
+	failed := make(chan struct{}, len(fs))
+	for f, l := range fs {
+		go t.waitFor(func() {
+			t.handleConns(f, l)
+			failed <- struct{}{}
+		})
+	}
+
+	select {
+	case <-t.stop:
+		log.Infof("%v: received stop signal", t.Name)
+	case <-failed:
+		log.Errorf("%v: listener failed, closing lazy tunnel", t.Name)
+	}
+	t.closeListeners(false)
+	t.closeConns()
+	t.wg.Wait()
+	t.lazyMu.Lock()
+	if t.idle != nil {
+		t.idle.Stop()
+	}
+	t.lazyMu.Unlock()
+	t.dropClient(nil, false)
+	t.setStatus(Closed)
+	close(t.Closed)
+}
+
+// lazyClient returns the client of a lazy tunnel, making it if needed
+func (t *Tunnel) lazyClient() (*ssh.Client, error) {
+	t.lazyMu.Lock()
+	defer t.lazyMu.Unlock()
+
+	if t.client != nil {
+		return t.client, nil
+	}
+
+	// Pick up changes since the last connection, see refreshRoutes
+	if !t.LastConn.IsZero() {
+		t.refreshRoutes()
+	}
+	if err := t.makeClient(); err != nil {
+		return nil, err
+	}
+	log.Infof("%v: connected on demand", t.Name)
+	t.mu.Lock()
+	t.Status = Open
+	t.LastConn = time.Now()
+	t.mu.Unlock()
+
+	c, pc, dropped := t.client, t.conn, make(chan struct{})
+	t.dropped = dropped
+	go t.waitFor(func() { t.keepAlive(c, dropped) })
+	go t.waitFor(func() { t.renewCert(c, dropped) })
+	go func() {
+		c.Wait()
+		t.dropClient(pc, false)
+	}()
+
+	return c, nil
+}
+
+// dropClient releases the client of a lazy tunnel, if any. If pc is
+// given, the client is only released if it is still the current one.
+// If ifIdle is set, it is only released without active connections.
+func (t *Tunnel) dropClient(pc *conn, ifIdle bool) {
+	t.lazyMu.Lock()
+	c := t.conn
+	if c == nil || (pc != nil && c != pc) ||
+		(ifIdle && t.stats.activeConns.Load() != 0) {
+		t.lazyMu.Unlock()
+		return
+	}
+	t.conn, t.client = nil, nil
+	close(t.dropped)
+	t.swapStatus(Open, Idle)
+	t.lazyMu.Unlock()
+
+	clients.release(c)
+	log.Infof("%v: dropped connection", t.Name)
+}
+
+// resetIdle (re)starts the idle timer of a lazy tunnel once there are no
+// more active connections.
+func (t *Tunnel) resetIdle() {
+	if !t.Lazy || t.stats.activeConns.Load() != 0 {
+		return
+	}
+	t.lazyMu.Lock()
+	defer t.lazyMu.Unlock()
+
+	if t.idle != nil {
+		t.idle.Stop()
+	}
+	d := durationOr(t.LazyIdle, defaultLazyIdle)
+	if d == time.Duration(Forever) {
+		return
+	}
+	t.idle = time.AfterFunc(d, func() { t.dropClient(nil, true) })
+}
+
+func (t *Tunnel) lazyDial(network, addr string) (net.Conn, error) {
+	c, err := t.lazyClient()
+	if err != nil {
+		return nil, err
+	}
+	return c.Dial(network, addr)
+}
@@ -0,0 +1,108 @@
+package tunnel
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/ssh_config"
+	"golang.org/x/crypto/ssh"
+)
+
+// pool shares SSH clients between tunnels that resolve to the same series
+// of hops. Clients are reference-counted and closed once the last tunnel
+// releases them. If a client disconnects, it is removed from the pool, so
+// that all dependent tunnels reconnect through a fresh one.
+type pool struct {
+	mu      sync.Mutex
+	entries map[string]*conn
+}
+
+// conn is a pooled client to the last hop of a chain
+type conn struct {
+	key    string
+	client *ssh.Client
+	refs   int
+	err    error
+	// ready is closed once the client is dialed, or dialing failed
+	ready chan struct{}
+	// done is closed once all clients of the chain have closed
+	done chan struct{}
+}
+
+var clients = &pool{entries: make(map[string]*conn)}
+
+// hopsKey identifies a series of hops for sharing clients. Clients are
+// only shared if all hops are authenticated and verified the same way,
+// e.g., such that tunnels pinning host keys don't get a client verified
+// against known_hosts only.
+func hopsKey(hops []ssh_config.Hop) string {
+	keys := make([]string, len(hops))
+	for i, h := range hops {
+		keys[i] = fmt.Sprintf("%v@%v:%v#%v", h.User, h.HostName, h.Port, h.SettingsID)
+	}
+	return strings.Join(keys, ",")
+}
+
+// acquire returns a client for the given key, dialing it if there is
+// none in the pool yet. Concurrent callers wait for the same dial.
+func (p *pool) acquire(key string,
+	dial func() (*ssh.Client, chan struct{}, error)) (*conn, error) {
+	p.mu.Lock()
+	c, ok := p.entries[key]
+	if ok {
+		c.refs++
+		p.mu.Unlock()
+		<-c.ready
+		if c.err != nil {
+			return nil, c.err
+		}
+		log.Debugf("reusing client %p for %v", c.client, key)
+		return c, nil
+	}
+
+	c = &conn{key: key, refs: 1, ready: make(chan struct{})}
+	p.entries[key] = c
+	p.mu.Unlock()
+
+	c.client, c.done, c.err = dial()
+	if c.err != nil {
+		p.remove(c)
+		close(c.ready)
+		return nil, c.err
+	}
+	close(c.ready)
+
+	// Drop disconnected clients, so that the next acquire dials again
+	go func() {
+		c.client.Wait()
+		p.remove(c)
+	}()
+	return c, nil
+}
+
+// release gives up a reference to c. The last reference closes the
+// client and waits for the whole chain to shut down.
+func (p *pool) release(c *conn) {
+	p.mu.Lock()
+	c.refs--
+	last := c.refs == 0
+	if last && p.entries[c.key] == c {
+		delete(p.entries, c.key)
+	}
+	p.mu.Unlock()
+
+	if last {
+		c.client.Close()
+		<-c.done
+	}
+}
+
+func (p *pool) remove(c *conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.entries[c.key] == c {
+		delete(p.entries, c.key)
+	}
+}
@@ -0,0 +1,42 @@
+package tunnel
+
+import (
+	"strings"
+
+	"github.com/alebeck/boring/internal/askpass"
+	"github.com/alebeck/boring/internal/ssh_config"
+)
+
+// SetPrompter sets a prompter answering authentication prompts, e.g.,
+// the CLI opening the tunnel. Unset it by passing nil.
+func (t *Tunnel) SetPrompter(p ssh_config.Prompter) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prompter = p
+}
+
+func (t *Tunnel) canPrompt() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.prompter != nil || askpass.Available()
+}
+
+// prompt answers authentication prompts through the prompter, if set,
+// and the askpass program otherwise, e.g., when re-connecting.
+func (t *Tunnel) prompt(msg string, echo bool) (string, error) {
+	t.mu.Lock()
+	p := t.prompter
+	t.mu.Unlock()
+	if p != nil {
+		return p(msg, echo)
+	}
+	// Yes/no questions, e.g., about updating host keys, are confirmations
+	if echo && strings.HasSuffix(msg, "(yes/no)? ") {
+		ok, err := askpass.Confirm(msg)
+		if err != nil || !ok {
+			return "no", err
+		}
+		return "yes", nil
+	}
+	return askpass.Ask(msg, echo)
+}
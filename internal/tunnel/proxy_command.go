@@ -0,0 +1,134 @@
+package tunnel
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alebeck/boring/internal/log"
+)
+
+const maxStderr = 4096
+
+// cmdConn is a connection over the stdin and stdout of a ProxyCommand.
+// Closing it terminates the command.
+type cmdConn struct {
+	cmd *exec.Cmd
+	// addr is the address of the hop, e.g., for known_hosts lookups
+	addr      string
+	stdin     io.WriteCloser
+	stdout    io.ReadCloser
+	stderr    *limitedBuffer
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+type cmdAddr string
+
+func (a cmdAddr) Network() string { return "proxycommand" }
+func (a cmdAddr) String() string  { return string(a) }
+
+// dialCommand starts a ProxyCommand for connecting to addr, like ssh(1)
+// through a shell
+func dialCommand(command, addr string) (*cmdConn, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", "exec "+command)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	// Not using StdoutPipe, as Wait would close it before all output is read
+	stdout, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = w
+	c := &cmdConn{
+		cmd:    cmd,
+		addr:   addr,
+		stdin:  stdin,
+		stdout: stdout,
+		stderr: &limitedBuffer{max: maxStderr},
+		done:   make(chan struct{}),
+	}
+	cmd.Stderr = c.stderr
+
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		stdout.Close()
+		return nil, fmt.Errorf("could not start proxy command: %v", err)
+	}
+	log.Debugf("started proxy command %q (pid %d)", command, cmd.Process.Pid)
+
+	go func() {
+		cmd.Wait()
+		close(c.done)
+	}()
+	return c, nil
+}
+
+func (c *cmdConn) Read(b []byte) (int, error)  { return c.stdout.Read(b) }
+func (c *cmdConn) Write(b []byte) (int, error) { return c.stdin.Write(b) }
+
+// Close ends the command, giving it a moment to exit on closed stdin
+func (c *cmdConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.stdin.Close()
+		select {
+		case <-c.done:
+		case <-time.After(time.Second):
+			c.cmd.Process.Kill()
+			<-c.done
+		}
+		c.stdout.Close()
+		log.Debugf("proxy command (pid %d) exited", c.cmd.Process.Pid)
+	})
+	return nil
+}
+
+// Stderr returns the last output of the command on stderr
+func (c *cmdConn) Stderr() string {
+	return strings.TrimSpace(c.stderr.String())
+}
+
+func (c *cmdConn) LocalAddr() net.Addr                { return cmdAddr("local") }
+func (c *cmdConn) RemoteAddr() net.Addr               { return cmdAddr(c.addr) }
+func (c *cmdConn) SetDeadline(t time.Time) error      { return nil }
+func (c *cmdConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *cmdConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// limitedBuffer keeps the last max bytes written to it
+type limitedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Write(p)
+	if over := b.buf.Len() - b.max; over > 0 {
+		b.buf.Next(over)
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
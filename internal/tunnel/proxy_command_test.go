@@ -0,0 +1,60 @@
+package tunnel
+
+import (
+	"io"
+	"runtime"
+	"testing"
+
+	"github.com/alebeck/boring/internal/log"
+)
+
+func TestCommandConn(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	log.Init(io.Discard, false, false)
+	c, err := dialCommand("cat", "localhost:22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("incorrect read %q: %v", buf, err)
+	}
+	c.Close()
+	select {
+	case <-c.done:
+	default:
+		t.Error("command still running after close")
+	}
+}
+
+func TestCommandConnStderr(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	log.Init(io.Discard, false, false)
+	c, err := dialCommand("echo failed >&2", "localhost:22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+	c.Close()
+	if s := c.Stderr(); s != "failed" {
+		t.Errorf("incorrect stderr: %q", s)
+	}
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	b := &limitedBuffer{max: 4}
+	io.WriteString(b, "abc")
+	io.WriteString(b, "defg")
+	if s := b.String(); s != "defg" {
+		t.Errorf("incorrect buffer: %q", s)
+	}
+}
@@ -0,0 +1,152 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/alebeck/boring/internal/log"
+)
+
+const (
+	defaultInitialWait = 500 * time.Millisecond
+	defaultMaxWait     = 1 * time.Minute
+	defaultMaxDuration = 15 * time.Minute
+	defaultHoldTimeout = 30 * time.Second
+)
+
+var errStopped = errors.New("re-connect interrupted by stop signal")
+
+// Reconnect describes the re-connection policy of a tunnel. Unset
+// fields fall back to the global policy, and then to the defaults.
+// Wait times are given in seconds, MaxDuration in minutes.
+type Reconnect struct {
+	// InitialWait is the wait time after the first failed attempt,
+	// it is doubled after each subsequent one
+	InitialWait *Timeout `toml:"initial_wait" json:"initial_wait"`
+	// MaxWait caps the wait time between attempts
+	MaxWait *Timeout `toml:"max_wait" json:"max_wait"`
+	// Jitter randomizes wait times by up to the given fraction, e.g. 0.1
+	Jitter *float64 `toml:"jitter" json:"jitter"`
+	// MaxDuration is the time after which to give up, can be "forever"
+	MaxDuration *Duration `toml:"max_duration" json:"max_duration"`
+	// MaxAttempts is the number of attempts after which to give up,
+	// 0 means unlimited
+	MaxAttempts *int `toml:"max_attempts" json:"max_attempts"`
+	// HoldTimeout is how long connections accepted while re-connecting
+	// are held before giving up on them
+	HoldTimeout *Timeout `toml:"hold_timeout" json:"hold_timeout"`
+}
+
+// Merge fills the unset fields of r from other
+func (r *Reconnect) Merge(other Reconnect) {
+	if r.InitialWait == nil {
+		r.InitialWait = other.InitialWait
+	}
+	if r.MaxWait == nil {
+		r.MaxWait = other.MaxWait
+	}
+	if r.Jitter == nil {
+		r.Jitter = other.Jitter
+	}
+	if r.MaxDuration == nil {
+		r.MaxDuration = other.MaxDuration
+	}
+	if r.MaxAttempts == nil {
+		r.MaxAttempts = other.MaxAttempts
+	}
+	if r.HoldTimeout == nil {
+		r.HoldTimeout = other.HoldTimeout
+	}
+}
+
+func (r *Reconnect) Validate() error {
+	if r.Jitter != nil && (*r.Jitter < 0 || *r.Jitter > 1) {
+		return fmt.Errorf("jitter must be between 0 and 1")
+	}
+	if r.MaxAttempts != nil && *r.MaxAttempts < 0 {
+		return fmt.Errorf("max_attempts must not be negative")
+	}
+	return nil
+}
+
+func durationOr(d *Duration, def time.Duration) time.Duration {
+	if d == nil {
+		return def
+	}
+	return time.Duration(*d)
+}
+
+func timeoutOr(d *Timeout, def time.Duration) time.Duration {
+	if d == nil {
+		return def
+	}
+	return time.Duration(*d)
+}
+
+func (r *Reconnect) jitter(d time.Duration) time.Duration {
+	if r.Jitter == nil || *r.Jitter == 0 {
+		return d
+	}
+	return d + time.Duration((rand.Float64()*2-1)**r.Jitter*float64(d))
+}
+
+func (t *Tunnel) reconnectLoop() error {
+	r := &t.Reconnect
+	t.setStatus(Reconn)
+
+	var timeout <-chan time.Time
+	if maxDur := durationOr(r.MaxDuration, defaultMaxDuration); maxDur != time.Duration(Forever) {
+		timeout = time.After(maxDur)
+	}
+	wait := time.NewTimer(2 * time.Millisecond) // First time try (essent.) immediately
+	waitTime := timeoutOr(r.InitialWait, defaultInitialWait)
+	maxWait := timeoutOr(r.MaxWait, defaultMaxWait)
+	var lastErr error
+
+	for attempts := 0; ; {
+		select {
+		case <-timeout:
+			return fmt.Errorf("re-connect timeout, last error: %v", lastErr)
+		case <-t.stop:
+			return errStopped
+		case <-wait.C:
+			log.Infof("%v: try re-connect...", t.Name)
+			t.refreshRoutes()
+			lastErr = t.Open()
+			if lastErr == nil {
+				t.stats.reconnects.Add(1)
+				t.reconnected()
+				return nil
+			}
+			attempts++
+			if r.MaxAttempts != nil && *r.MaxAttempts > 0 && attempts >= *r.MaxAttempts {
+				return fmt.Errorf("gave up after %d attempts, last error: %v", attempts, lastErr)
+			}
+			w := r.jitter(waitTime)
+			log.Errorf("%v: could not re-connect: %v. Retrying in %v...",
+				t.Name, lastErr, w)
+			wait.Reset(w)
+			waitTime = min(waitTime*2, maxWait)
+		}
+	}
+}
+
+// refreshRoutes re-resolves the hops to all hosts of the tunnel, such that
+// changes of the SSH config, renewed certificates and new agent keys are
+// picked up. Hosts which cannot be resolved anymore keep their last hops.
+func (t *Tunnel) refreshRoutes() {
+	for i := range t.routes {
+		r := &t.routes[i]
+		hops, err := t.resolveHops(r.host)
+		if err != nil {
+			if r.err == nil {
+				log.Warningf("%v: could not re-resolve host %v, using previous "+
+					"configuration: %v", t.Name, r.host, err)
+			}
+			continue
+		}
+		r.hops, r.err = hops, nil
+	}
+}
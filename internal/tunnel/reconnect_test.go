@@ -0,0 +1,70 @@
+package tunnel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestReconnectMerge(t *testing.T) {
+	wait, attempts, global := Timeout(time.Second), 3, 5
+	r := Reconnect{MaxAttempts: &attempts}
+	r.Merge(Reconnect{InitialWait: &wait, MaxAttempts: &global})
+	if r.InitialWait == nil || *r.InitialWait != wait {
+		t.Errorf("initial wait not merged: %v", r.InitialWait)
+	}
+	if *r.MaxAttempts != 3 {
+		t.Errorf("max attempts overridden: %v", *r.MaxAttempts)
+	}
+	if r.MaxWait != nil || r.Jitter != nil || r.MaxDuration != nil {
+		t.Errorf("unset fields should stay nil")
+	}
+}
+
+func TestReconnectValidate(t *testing.T) {
+	jitter, forever, attempts := 1.5, Forever, -1
+	for _, r := range []Reconnect{
+		{Jitter: &jitter},
+		{MaxAttempts: &attempts},
+	} {
+		if err := r.Validate(); err == nil {
+			t.Errorf("expected error for %+v", r)
+		}
+	}
+	if err := (&Reconnect{MaxDuration: &forever}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestReconnectJitter(t *testing.T) {
+	j := 0.5
+	r := Reconnect{Jitter: &j}
+	for range 100 {
+		if d := r.jitter(time.Second); d < 500*time.Millisecond || d > 1500*time.Millisecond {
+			t.Fatalf("jitter out of range: %v", d)
+		}
+	}
+	if d := (&Reconnect{}).jitter(time.Second); d != time.Second {
+		t.Errorf("unexpected jitter: %v", d)
+	}
+}
+
+func TestReconnectUnits(t *testing.T) {
+	var r Reconnect
+	if _, err := toml.Decode("initial_wait = 2\nmax_duration = 2\nhold_timeout = \"1m\"", &r); err != nil {
+		t.Fatal(err)
+	}
+	if time.Duration(*r.InitialWait) != 2*time.Second {
+		t.Errorf("integer wait not in seconds: %v", time.Duration(*r.InitialWait))
+	}
+	if time.Duration(*r.MaxDuration) != 2*time.Minute {
+		t.Errorf("integer max duration not in minutes: %v", r.MaxDuration)
+	}
+	if time.Duration(*r.HoldTimeout) != time.Minute {
+		t.Errorf("incorrect hold timeout: %v", time.Duration(*r.HoldTimeout))
+	}
+	if _, err := toml.Decode(`max_wait = "forever"`, &r); err == nil {
+		t.Errorf("expected error for forever wait")
+	}
+}
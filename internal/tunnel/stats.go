@@ -0,0 +1,69 @@
+package tunnel
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds traffic and connection statistics of a running tunnel.
+// Bytes are counted from the perspective of connections accepted by
+// the tunnel, i.e., sent bytes go towards the forwarding destination.
+type Stats struct {
+	BytesSent     uint64 `json:"bytes_sent"`
+	BytesReceived uint64 `json:"bytes_received"`
+	ActiveConns   int64  `json:"active_conns"`
+	TotalConns    uint64 `json:"total_conns"`
+	FailedDials   uint64 `json:"failed_dials"`
+	Reconnects    uint64 `json:"reconnects"`
+}
+
+// stats is the concurrently updated counterpart of Stats
+type stats struct {
+	bytesSent     atomic.Uint64
+	bytesReceived atomic.Uint64
+	activeConns   atomic.Int64
+	totalConns    atomic.Uint64
+	failedDials   atomic.Uint64
+	reconnects    atomic.Uint64
+	// Last time a connection was closed, in Unix nanoseconds
+	lastActive atomic.Int64
+}
+
+// CollectStats returns a snapshot of the tunnel's statistics
+func (t *Tunnel) CollectStats() *Stats {
+	return &Stats{
+		BytesSent:     t.stats.bytesSent.Load(),
+		BytesReceived: t.stats.bytesReceived.Load(),
+		ActiveConns:   t.stats.activeConns.Load(),
+		TotalConns:    t.stats.totalConns.Load(),
+		FailedDials:   t.stats.failedDials.Load(),
+		Reconnects:    t.stats.reconnects.Load(),
+	}
+}
+
+// IdleFor returns for how long the tunnel has had no active connections
+func (t *Tunnel) IdleFor() time.Duration {
+	if t.stats.activeConns.Load() > 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, t.stats.lastActive.Load()))
+}
+
+// countConn counts the bytes read from and written to an accepted connection
+type countConn struct {
+	net.Conn
+	s *stats
+}
+
+func (c *countConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.s.bytesSent.Add(uint64(n))
+	return n, err
+}
+
+func (c *countConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.s.bytesReceived.Add(uint64(n))
+	return n, err
+}
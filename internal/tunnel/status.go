@@ -1,9 +1,63 @@
 package tunnel
 
+import "slices"
+
 type Status int
 
 const (
 	Closed Status = iota
 	Open
 	Reconn
+	// Idle indicates a lazy tunnel which is listening but not connected
+	Idle
+	// Failed indicates a tunnel which gave up re-connecting
+	Failed
+	// Degraded indicates an open tunnel whose health check fails
+	Degraded
 )
+
+// setStatus changes the status of the tunnel. Like other fields changing
+// while the tunnel runs, it is guarded by mu, since the daemon reads it
+// concurrently, see Snapshot.
+func (t *Tunnel) setStatus(s Status) {
+	t.mu.Lock()
+	t.Status = s
+	t.mu.Unlock()
+}
+
+// setFailed changes the status of the tunnel to s, which is Failed or
+// Degraded, keeping err as the last error
+func (t *Tunnel) setFailed(s Status, err string) {
+	t.mu.Lock()
+	t.Status, t.LastError = s, err
+	t.mu.Unlock()
+}
+
+// swapStatus changes the status of the tunnel from old to s and tells
+// whether it did, the status is left as is if it is not old
+func (t *Tunnel) swapStatus(old, s Status) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Status != old {
+		return false
+	}
+	t.Status = s
+	return true
+}
+
+// State returns the current status of the tunnel
+func (t *Tunnel) State() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.Status
+}
+
+// Snapshot returns a copy of the description of the tunnel, including its
+// current status and the addresses its forwards are bound to
+func (t *Tunnel) Snapshot() Desc {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	desc := *t.Desc
+	desc.Forwards = slices.Clone(t.Forwards)
+	return desc
+}
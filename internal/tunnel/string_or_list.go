@@ -0,0 +1,27 @@
+package tunnel
+
+import "fmt"
+
+// Custom type to handle both a single string and a list of strings
+// in the TOML config, e.g., for pinned host keys.
+type StringOrList []string
+
+func (s *StringOrList) UnmarshalTOML(v any) error {
+	switch value := v.(type) {
+	case string:
+		*s = StringOrList{value}
+	case []any:
+		l := make(StringOrList, 0, len(value))
+		for _, e := range value {
+			str, ok := e.(string)
+			if !ok {
+				return fmt.Errorf("unsupported list element type: %T", e)
+			}
+			l = append(l, str)
+		}
+		*s = l
+	default:
+		return fmt.Errorf("unsupported type: %T", v)
+	}
+	return nil
+}
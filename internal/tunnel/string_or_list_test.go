@@ -0,0 +1,34 @@
+package tunnel
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestStringOrList(t *testing.T) {
+	var v struct {
+		A StringOrList `toml:"a"`
+		B StringOrList `toml:"b"`
+	}
+	if _, err := toml.Decode(`a = "x"
+b = ["x", "y"]`, &v); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v.A, StringOrList{"x"}) {
+		t.Errorf("incorrect single value: %v", v.A)
+	}
+	if !reflect.DeepEqual(v.B, StringOrList{"x", "y"}) {
+		t.Errorf("incorrect list: %v", v.B)
+	}
+}
+
+func TestStringOrListInvalid(t *testing.T) {
+	var v struct {
+		A StringOrList `toml:"a"`
+	}
+	if _, err := toml.Decode(`a = [1, 2]`, &v); err == nil {
+		t.Error("expected error for non-string elements")
+	}
+}
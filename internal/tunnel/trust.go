@@ -0,0 +1,41 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/alebeck/boring/internal/ssh_config"
+)
+
+// Trust connects to all hosts of a tunnel, including jump hosts, and
+// passes their host keys to trust. Unknown keys which are trusted are
+// added to the known hosts, changed keys are rejected. The connections
+// are closed again afterwards. Tunnels connecting via another tunnel are
+// refused, since it only runs within the daemon, and dialing directly
+// would show host keys seen on a different network path.
+func Trust(desc *Desc, trust ssh_config.TrustFunc, prompt ssh_config.Prompter) error {
+	if desc.ViaTunnel != "" {
+		return fmt.Errorf("hosts reached via tunnel '%v' cannot be trusted, "+
+			"use StrictHostKeyChecking accept-new instead", desc.ViaTunnel)
+	}
+	t := FromDesc(desc)
+	t.trust = trust
+	t.prompter = prompt
+
+	var errs []error
+	for _, host := range t.AllHosts() {
+		hops, err := t.resolveHops(host)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v: %v", host, err))
+			continue
+		}
+		c, done, err := t.dialHops(hops)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v: %v", host, err))
+			continue
+		}
+		c.Close()
+		<-done
+	}
+	return errors.Join(errs...)
+}
@@ -2,6 +2,7 @@ package tunnel
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -16,41 +17,125 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
-const (
-	initReconnectWait = 500 * time.Millisecond
-	maxReconnectWait  = 1 * time.Minute
-	reconnectTimeout  = 15 * time.Minute
-)
+// defaultKeepAlive applies if neither the tunnel nor ssh_config set one
+const defaultKeepAlive = 2 * time.Minute
+
+// ErrClosing is returned when closing a tunnel which is already closing
+var ErrClosing = errors.New("tunnel is already closing")
 
 // Desc describes a tunnel for user-facing purposes, e.g., in the config file
 // and in the TUI.
 type Desc struct {
-	Name          string      `toml:"name" json:"name"`
-	LocalAddress  StringOrInt `toml:"local" json:"local"`
-	RemoteAddress StringOrInt `toml:"remote" json:"remote"`
-	Host          string      `toml:"host" json:"host"`
-	User          string      `toml:"user" json:"user"`
-	IdentityFile  string      `toml:"identity" json:"identity"`
-	Port          int         `toml:"port" json:"port"`
-	KeepAlive     *int        `toml:"keep_alive" json:"keep_alive"`
-	Group         string      `toml:"group" json:"group"`
-	Mode          Mode        `toml:"mode" json:"mode"`
-	Status        Status      `toml:"-" json:"status"`
-	LastConn      time.Time   `toml:"-" json:"last_conn"`
+	Name string `toml:"name" json:"name"`
+	// Forward holds the forward specified at the top level of the tunnel.
+	Forward
+	// Forwards holds additional forwards sharing the same connection.
+	Forwards     []Forward    `toml:"forwards" json:"forwards,omitempty"`
+	Host         string       `toml:"host" json:"host"`
+	Hosts        []string     `toml:"hosts" json:"hosts,omitempty"`
+	HostSelect   string       `toml:"host_select" json:"host_select,omitempty"`
+	User         string       `toml:"user" json:"user"`
+	IdentityFile string       `toml:"identity" json:"identity"`
+	Port         int          `toml:"port" json:"port"`
+	KeepAlive    *int         `toml:"keep_alive" json:"keep_alive"`
+	Group        string       `toml:"group" json:"group"`
+	Lazy         bool         `toml:"lazy" json:"lazy"`
+	LazyIdle     *Duration    `toml:"lazy_idle" json:"lazy_idle"`
+	IdleTimeout  Duration     `toml:"idle_timeout" json:"idle_timeout"`
+	TTL          Duration     `toml:"ttl" json:"ttl"`
+	Reconnect    Reconnect    `toml:"reconnect" json:"reconnect"`
+	Health       *HealthCheck `toml:"health" json:"health,omitempty"`
+	// HostKey, HostKeyFingerprint and HostCA pin the keys accepted from
+	// hops, instead of checking them against known_hosts. Pins prefixed
+	// with host patterns apply to the matching hops, others to the target
+	// host only. Hops without pins are still checked against known_hosts.
+	HostKey            StringOrList `toml:"host_key" json:"host_key,omitempty"`
+	HostKeyFingerprint StringOrList `toml:"host_key_fingerprint" json:"host_key_fingerprint,omitempty"`
+	HostCA             StringOrList `toml:"host_ca" json:"host_ca,omitempty"`
+	// IdentityAgent overrides the IdentityAgent of ssh_config for all hops,
+	// several agents can be given.
+	IdentityAgent StringOrList `toml:"identity_agent" json:"identity_agent,omitempty"`
+	// KeepAliveCountMax, ConnectTimeout and ConnectionAttempts override the
+	// ServerAliveCountMax, ConnectTimeout and ConnectionAttempts of ssh_config.
+	KeepAliveCountMax  *int     `toml:"keep_alive_count_max" json:"keep_alive_count_max,omitempty"`
+	ConnectTimeout     *Timeout `toml:"connect_timeout" json:"connect_timeout,omitempty"`
+	ConnectionAttempts *int     `toml:"connection_attempts" json:"connection_attempts,omitempty"`
+	// HostDefs holds the definitions of all hosts of the tunnel which are
+	// defined in the boring config, including their jump hosts.
+	HostDefs HostDefs `toml:"-" json:"host_defs,omitempty"`
+	// ViaTunnel names a tunnel through which the first hop is dialed instead
+	// of the network, in the way given by ViaMode. Via is its description,
+	// which is opened first if the tunnel is not running.
+	ViaTunnel string `toml:"via_tunnel" json:"via_tunnel,omitempty"`
+	ViaMode   string `toml:"via_mode" json:"via_mode,omitempty"`
+	Via       *Desc  `toml:"-" json:"via,omitempty"`
+	// DependsOn names tunnels which are opened before the tunnel, and whose
+	// closing and re-connecting is cascaded to it. Deps are their
+	// descriptions.
+	DependsOn []string `toml:"depends_on" json:"depends_on,omitempty"`
+	Deps      []*Desc  `toml:"-" json:"deps,omitempty"`
+	// Proxy is the URL of an upstream SOCKS5 or HTTP proxy through which
+	// the first hop is dialed, "none" ignores $ALL_PROXY.
+	Proxy string `toml:"proxy" json:"proxy,omitempty"`
+	// CertRenewCommand is run before the certificate used for connecting
+	// expires, by CertRenewBefore, see renewCert.
+	CertRenewCommand string    `toml:"cert_renew_command" json:"cert_renew_command,omitempty"`
+	CertRenewBefore  *Duration `toml:"cert_renew_before" json:"cert_renew_before,omitempty"`
+	// PassphraseCache is the time for which decrypted keys are kept in the
+	// daemon. Nil means forever.
+	PassphraseCache *Duration `toml:"passphrase_cache" json:"passphrase_cache,omitempty"`
+	Status          Status    `toml:"-" json:"status"`
+	LastConn        time.Time `toml:"-" json:"last_conn"`
+	Deadline        time.Time `toml:"-" json:"deadline"`
+	LastError       string    `toml:"-" json:"last_error,omitempty"`
+	ActiveHost      string    `toml:"-" json:"active_host,omitempty"`
+	CertExpiry      time.Time `toml:"-" json:"cert_expiry,omitempty"`
+	Stats           *Stats    `toml:"-" json:"stats,omitempty"`
 }
 
 // Tunnel is a representation internal to the tunnel and daemon packages,
 // describing a tunnel that is running or about to be run.
 type Tunnel struct {
-	prepared   bool
-	hops       []ssh_config.Hop
-	Closed     chan struct{}
-	stop       chan struct{}
-	listener   net.Listener
-	wg         sync.WaitGroup
-	client     *ssh.Client
-	localAddr  *address
-	remoteAddr *address
+	prepared bool
+	routes   []route
+	Closed   chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	forwards []*forward
+	wg       sync.WaitGroup
+	conn     *conn
+	client   *ssh.Client
+	// Address checked by health checks, see health.go
+	healthAddr *address
+	// ready is closed while the client is connected, see waitClient
+	ready chan struct{}
+	// prompter answers authentication prompts while opening interactively
+	prompter ssh_config.Prompter
+	// trust decides about unknown host keys, see Trust
+	trust ssh_config.TrustFunc
+	// via is the running tunnel the first hop is dialed through, see SetVia
+	via *Tunnel
+	// onReconnect is called after re-connecting, see SetOnReconnect
+	onReconnect func()
+	// disconnect makes the tunnel re-connect without closing its client,
+	// which may be shared, see ForceReconnect
+	disconnect func()
+	// Keep-alive settings of the connected host, see keepAlive
+	aliveInterval time.Duration
+	aliveCountMax int
+	// certKey identifies the certificate expiring at CertExpiry, see renewCert
+	certKey string
+	// mu guards client, conn, ready, prompter, via, onReconnect, disconnect,
+	// keep-alive settings and the listeners of the forwards
+	mu sync.Mutex
+	// Accepted connections, closed on stop since the client may outlive the tunnel
+	conns   map[net.Conn]struct{}
+	connsMu sync.Mutex
+	stats   stats
+	// State of lazy tunnels, see lazy.go
+	lazyMu  sync.Mutex
+	idle    *time.Timer
+	dropped chan struct{}
 	*Desc
 }
 
@@ -63,44 +148,173 @@ func FromDesc(desc *Desc) *Tunnel {
 }
 
 func (t *Tunnel) Open() (err error) {
+	// Start the idle clock on first open
+	t.stats.lastActive.CompareAndSwap(0, time.Now().UnixNano())
+
 	if !t.prepared {
 		if err = t.prepare(); err != nil {
 			return err
 		}
 	}
 
+	if t.Lazy {
+		return t.openLazy()
+	}
+
 	if err = t.makeClient(); err != nil {
 		return err
 	}
 	log.Debugf("%v: connected to server", t.Name)
 
-	if err = t.makeListener(); err != nil {
-		t.client.Close()
+	// Local listeners are kept open while re-connecting, so only
+	// the missing ones are set up here
+	fs, err := t.makeListeners()
+	if err != nil {
+		clients.release(t.conn)
 		return fmt.Errorf("cannot listen: %v", err)
 	}
-	log.Debugf("%v: listening on %v", t.Name, t.listener.Addr())
 
 	if t.stop == nil {
 		t.stop = make(chan struct{})
 		t.Closed = make(chan struct{})
 	}
 
-	go t.run()
+	// Set up disconnecting before serving, since failing listeners
+	// disconnect the tunnel
+	disconn := make(chan struct{})
+	var once sync.Once
+	disconnect := func() { once.Do(func() { close(disconn) }) }
+	t.mu.Lock()
+	if t.ready == nil {
+		t.ready = make(chan struct{})
+	}
+	close(t.ready)
+	t.disconnect = disconnect
+	t.mu.Unlock()
+
+	for f, l := range fs {
+		go t.waitFor(func() { t.serve(f, l) })
+	}
+	go t.run(disconn, disconnect)
 
 	log.Infof("%v: opened tunnel", t.Name)
+	t.mu.Lock()
 	t.Status = Open
 	t.LastConn = time.Now()
+	t.LastError = ""
+	t.mu.Unlock()
 	return
 }
 
 func (t *Tunnel) prepare() error {
+	hosts := t.AllHosts()
+	if len(hosts) == 0 {
+		return fmt.Errorf("no host specified")
+	}
+	if t.HostSelect != "" && t.HostSelect != "order" && t.HostSelect != "latency" {
+		return fmt.Errorf("unknown host selection '%v'", t.HostSelect)
+	}
+	if err := t.validateProxy(); err != nil {
+		return err
+	}
+	// Hosts which cannot be resolved are skipped, unless all of them fail
+	t.routes = make([]route, 0, len(hosts))
+	var resolved bool
+	for _, h := range hosts {
+		hops, err := t.resolveHops(h)
+		if err != nil && len(hosts) > 1 {
+			log.Warningf("%v: skipping host %v: %v", t.Name, h, err)
+		}
+		resolved = resolved || err == nil
+		t.routes = append(t.routes, route{host: h, hops: hops, err: err})
+	}
+	if !resolved {
+		return t.routes[0].err
+	}
+
+	fs := t.forwardRefs()
+	if len(fs) == 0 {
+		return fmt.Errorf("no forwards specified")
+	}
+	t.forwards = make([]*forward, 0, len(fs))
+	for _, f := range fs {
+		fw, err := makeForward(f)
+		if err != nil {
+			return err
+		}
+		t.forwards = append(t.forwards, fw)
+	}
+
+	if t.Health != nil {
+		var err error
+		if t.healthAddr, err = t.makeHealthAddr(); err != nil {
+			return fmt.Errorf("invalid health check: %v", err)
+		}
+	}
+
+	t.prepared = true
+
+	return nil
+}
+
+// resolveHops infers the series of hops to the given host from ssh config,
+// or from the host definitions of the boring config
+func (t *Tunnel) resolveHops(host string) ([]ssh_config.Hop, error) {
+	if _, ok := t.HostDefs[host]; ok {
+		return t.definedHops(host)
+	}
+
 	// We need to pass the user as it's needed for matching Match blocks
-	sc, err := ssh_config.ParseSSHConfig(t.Host, t.User)
+	sc, err := ssh_config.ParseSSHConfig(host, t.User)
 	if err != nil {
-		return fmt.Errorf("could not parse SSH config: %v", err)
+		return nil, fmt.Errorf("could not parse SSH config: %v", err)
+	}
+
+	// If the host could not be resolved from ssh config, take it literally
+	if sc.HostName == "" {
+		sc.HostName = host
 	}
 
+	if err := t.configure(sc, true); err != nil {
+		return nil, err
+	}
+
+	// Infer series of hops from ssh config
+	return sc.ToHops()
+}
+
+// configure applies the tunnel options to the config of a hop. Options
+// concerning the destination only apply if target is set, the others are
+// passed on to jump hosts by ssh_config.
+func (t *Tunnel) configure(sc *ssh_config.SSHConfig, target bool) (err error) {
 	// Override values manually set by user
+	if target {
+		t.configureTarget(sc)
+	}
+	if len(t.IdentityAgent) > 0 {
+		sc.OverrideIdentityAgents(ssh_config.ResolveIdentityAgents(t.IdentityAgent))
+	}
+
+	sc.EnsureUser()
+
+	// Only try password authentication if prompts can be answered
+	if t.canPrompt() {
+		sc.Prompt = t.prompt
+	}
+	sc.Trust = t.trust
+	sc.Jump = !target
+	if sc.Pins, err = ssh_config.ParseHostKeyPins(
+		t.HostKey, t.HostKeyFingerprint, t.HostCA); err != nil {
+		return err
+	}
+	sc.PassphraseCache = time.Duration(Forever)
+	if t.PassphraseCache != nil {
+		sc.PassphraseCache = time.Duration(*t.PassphraseCache)
+	}
+	return nil
+}
+
+func (t *Tunnel) configureTarget(sc *ssh_config.SSHConfig) {
 	if t.User != "" {
 		sc.User = t.User
 	}
@@ -111,51 +325,103 @@ func (t *Tunnel) prepare() error {
 		sc.IdentityFiles = []string{t.IdentityFile}
 	}
 
-	// If t.Host could not be resolved from ssh config, take it literally
-	if sc.HostName == "" {
-		sc.HostName = t.Host
+	// Tunnel options take precedence over ssh_config. Since ssh_config
+	// disables keep-alives by default, ServerAliveInterval only applies if
+	// set to a positive value.
+	if t.KeepAlive != nil {
+		sc.ServerAliveInterval = time.Duration(*t.KeepAlive) * time.Second
+	} else if sc.ServerAliveInterval <= 0 {
+		sc.ServerAliveInterval = defaultKeepAlive
 	}
-
-	sc.EnsureUser()
-
-	// Infer series of hops from ssh config
-	if t.hops, err = sc.ToHops(); err != nil {
-		return err
+	if t.KeepAliveCountMax != nil {
+		sc.ServerAliveCountMax = *t.KeepAliveCountMax
 	}
-
-	allowShort := t.Mode == Remote || t.Mode == RemoteSocks
-	t.remoteAddr, err = parseAddr(string(t.RemoteAddress), allowShort)
-	if err != nil {
-		return fmt.Errorf("remote address: %v", err)
+	if t.ConnectTimeout != nil {
+		sc.ConnectTimeout = time.Duration(*t.ConnectTimeout)
 	}
-
-	t.localAddr, err = parseAddr(string(t.LocalAddress), !allowShort)
-	if err != nil {
-		return fmt.Errorf("local address: %v", err)
+	if t.ConnectionAttempts != nil {
+		sc.ConnectionAttempts = *t.ConnectionAttempts
 	}
-
-	t.prepared = true
-
-	return nil
 }
 
+// makeClient acquires a client for the tunnel's hops, which is shared
+// with all other tunnels resolving to the same series of hops. With
+// several hosts, they are tried in order until one succeeds.
 func (t *Tunnel) makeClient() error {
-	if len(t.hops) == 0 {
+	routes := t.routes
+	if len(routes) == 0 {
 		return fmt.Errorf("no connections specified")
 	}
+	if t.HostSelect == "latency" && len(routes) > 1 {
+		routes = t.byLatency(routes)
+	}
 
+	var err error
+	for _, r := range routes {
+		if r.err != nil {
+			err = r.err
+			continue
+		}
+		if len(r.hops) == 0 {
+			err = fmt.Errorf("no connections specified")
+			continue
+		}
+		var c *conn
+		key := hopsKey(r.hops)
+		// Don't share clients with tunnels dialing the first hop differently
+		if t.ViaTunnel != "" {
+			key = t.ViaTunnel + "/" + key
+		} else if p := t.proxyKey(); p != "" {
+			key = p + "/" + key
+		}
+		c, err = clients.acquire(key, func() (*ssh.Client, chan struct{}, error) {
+			return t.dialHops(r.hops)
+		})
+		if err != nil {
+			if len(routes) > 1 {
+				log.Warningf("%v: could not connect via %v: %v", t.Name, r.host, err)
+			}
+			continue
+		}
+		last := r.hops[len(r.hops)-1]
+		t.mu.Lock()
+		t.conn = c
+		t.client = c.client
+		t.ActiveHost = r.host
+		t.aliveInterval, t.aliveCountMax = last.KeepAlive, last.KeepAliveCountMax
+		t.CertExpiry, t.certKey = routeCertExpiry(r.hops)
+		t.mu.Unlock()
+		return nil
+	}
+	if len(routes) > 1 {
+		return fmt.Errorf("could not connect via any host, last error: %v", err)
+	}
+	return err
+}
+
+// dialHops connects through all hops. The returned channel is closed
+// once all clients of the chain have closed.
+func (t *Tunnel) dialHops(hops []ssh_config.Hop) (*ssh.Client, chan struct{}, error) {
 	var c *ssh.Client
 	var wg sync.WaitGroup
 
-	// Connect through all jump hosts
-	for _, j := range t.hops {
+	// Connect through all jump hosts, the first one via another tunnel
+	// or a proxy if configured
+	var dial hopDial
+	if len(hops) > 0 {
+		var err error
+		if dial, err = t.firstDial(hops[0]); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, j := range hops {
 		addr := fmt.Sprintf("%v:%v", j.HostName, j.Port)
-		n, err := wrapClient(c, addr, j.ClientConfig)
+		n, err := wrapClient(dial, addr, j)
 		if err != nil {
 			safeClose(c)
 			// Wait for all connections established until here to close
 			wg.Wait()
-			return fmt.Errorf("could not connect to host %v: %v", addr, err)
+			return nil, nil, fmt.Errorf("could not connect to host %v: %v", addr, err)
 		}
 		log.Debugf("%v: connected to host %v (client %p)", t.Name, j.HostName, n)
 
@@ -169,133 +435,375 @@ func (t *Tunnel) makeClient() error {
 			safeClose(c)
 		}(n, c)
 
-		c = n
+		c, dial = n, n.Dial
 	}
 
 	// Wait for all wrapped clients to close in case of tunnel closing or reconnection
-	go t.waitFor(func() { wg.Wait() })
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
 
-	t.client = c
-	return nil
+	return c, done, nil
+}
+
+// firstDial returns the dial function for connecting to the first hop,
+// or nil if it is dialed through the network or its ProxyCommand
+func (t *Tunnel) firstDial(hop ssh_config.Hop) (hopDial, error) {
+	if dial := t.viaDial(); dial != nil {
+		log.Debugf("%v: connecting via tunnel %v", t.Name, t.ViaTunnel)
+		return dial, nil
+	}
+	// Proxy commands take precedence over upstream proxies
+	if hop.ProxyCommand != "" {
+		return nil, nil
+	}
+	return t.proxyDial(hop)
+}
+
+// wrapClient connects to a hop by dialing addr through dial, e.g., the
+// client to the previous hop, or through the network if dial is nil.
+func wrapClient(dial hopDial, addr string, hop ssh_config.Hop) (*ssh.Client, error) {
+	if dial == nil && hop.ProxyCommand != "" {
+		return commandClient(addr, hop)
+	}
+
+	// Like ssh(1), wait a second between connection attempts
+	var conn net.Conn
+	var err error
+	for i := 0; i < max(hop.Attempts, 1); i++ {
+		if i > 0 {
+			log.Debugf("could not connect to %v, trying again: %v", addr, err)
+			time.Sleep(time.Second)
+		}
+		if dial == nil {
+			conn, err = net.DialTimeout("tcp", addr, hop.Timeout)
+		} else {
+			conn, err = dial("tcp", addr)
+		}
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newClient(conn, addr, hop)
 }
 
-func wrapClient(old *ssh.Client, addr string, conf *ssh.ClientConfig) (*ssh.Client, error) {
-	if old == nil {
-		return ssh.Dial("tcp", addr, conf)
+// newClient establishes an SSH connection to a hop over conn. The
+// handshake is subject to the hop's connect timeout.
+func newClient(conn net.Conn, addr string, hop ssh_config.Hop) (*ssh.Client, error) {
+	if hop.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(hop.Timeout))
 	}
+	ncc, chans, reqs, err := ssh.NewClientConn(conn, addr, hop.ClientConfig)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(ncc, chans, hop.HandleRequests(ncc, reqs)), nil
+}
 
-	conn, err := old.Dial("tcp", addr)
+// commandClient connects to a hop through its ProxyCommand. The command
+// is terminated once the client closes.
+func commandClient(addr string, hop ssh_config.Hop) (*ssh.Client, error) {
+	conn, err := dialCommand(hop.ProxyCommand, addr)
 	if err != nil {
 		return nil, err
 	}
 
-	ncc, chans, reqs, err := ssh.NewClientConn(conn, addr, conf)
+	// ssh.Dial's timeout does not apply here, so enforce it ourselves
+	if hop.Timeout > 0 {
+		timer := time.AfterFunc(hop.Timeout, func() { conn.Close() })
+		defer timer.Stop()
+	}
+
+	c, err := newClient(conn, addr, hop)
 	if err != nil {
+		conn.Close()
+		if out := conn.Stderr(); out != "" {
+			return nil, fmt.Errorf("%v (proxy command: %v)", err, out)
+		}
 		return nil, err
 	}
+	go func() {
+		c.Wait()
+		conn.Close()
+	}()
+	return c, nil
+}
 
-	return ssh.NewClient(ncc, chans, reqs), nil
+// makeListeners sets up the listeners of all forwards which have none
+// and returns them by forward, since the listeners of forwards may be
+// closed and reset concurrently. If any of them fails, the ones set up
+// are closed again.
+func (t *Tunnel) makeListeners() (map[*forward]net.Listener, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	fs := make(map[*forward]net.Listener)
+	for _, f := range t.forwards {
+		if f.listener != nil {
+			continue
+		}
+		var err error
+		if f.isRemote() {
+			f.listener, err = t.client.Listen(f.remoteAddr.net, f.remoteAddr.addr)
+		} else {
+			f.listener, err = net.Listen(f.localAddr.net, f.localAddr.addr)
+		}
+		if err != nil {
+			f.listener = nil
+			for g, l := range fs {
+				l.Close()
+				g.listener = nil
+			}
+			return nil, err
+		}
+		f.desc.Bound = f.listener.Addr().String()
+		log.Debugf("%v: listening on %v", t.Name, f.desc.Bound)
+		fs[f] = f.listener
+	}
+	return fs, nil
 }
 
-func (t *Tunnel) makeListener() (err error) {
-	if t.Mode == Remote || t.Mode == RemoteSocks {
-		t.listener, err = t.client.Listen(t.remoteAddr.net, t.remoteAddr.addr)
-	} else {
-		t.listener, err = net.Listen(t.localAddr.net, t.localAddr.addr)
+// closeListeners closes the listeners of all forwards, or only those
+// of remote forwards, which cannot outlive the client.
+func (t *Tunnel) closeListeners(remoteOnly bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, f := range t.forwards {
+		if f.listener != nil && (f.isRemote() || !remoteOnly) {
+			f.listener.Close()
+			f.listener = nil
+		}
 	}
-	return
 }
 
-func (t *Tunnel) dial(network, addr string) (net.Conn, error) {
-	if t.Mode == Remote || t.Mode == RemoteSocks {
+func (t *Tunnel) dial(f *forward, network, addr string) (net.Conn, error) {
+	if f.isRemote() {
 		return net.Dial(network, addr)
 	}
-	return t.client.Dial(network, addr)
+	if t.Lazy {
+		return t.lazyDial(network, addr)
+	}
+	c, err := t.waitClient()
+	if err != nil {
+		return nil, err
+	}
+	return c.Dial(network, addr)
 }
 
-func (t *Tunnel) run() {
-	disconn := make(chan struct{})
+// waitClient returns the current client. While re-connecting, callers
+// are held until the new client is up, or the hold timeout passes.
+func (t *Tunnel) waitClient() (*ssh.Client, error) {
+	t.mu.Lock()
+	ready, c := t.ready, t.client
+	t.mu.Unlock()
+	select {
+	case <-ready:
+		return c, nil
+	default:
+	}
+
+	timeout := time.NewTimer(timeoutOr(t.Reconnect.HoldTimeout, defaultHoldTimeout))
+	defer timeout.Stop()
+	select {
+	case <-ready:
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		return t.client, nil
+	case <-timeout.C:
+		return nil, fmt.Errorf("timed out waiting for re-connect")
+	case <-t.stop:
+		return nil, fmt.Errorf("tunnel is closing")
+	}
+}
+
+// serve handles a forward until its listener fails, in which case the
+// tunnel re-connects and reopens the listener. The client is left open,
+// since it may be shared with other tunnels.
+func (t *Tunnel) serve(f *forward, l net.Listener) {
+	t.handleConns(f, l)
+	select {
+	case <-t.stop:
+		// The client may still be used by other tunnels
+		return
+	default:
+	}
+	t.mu.Lock()
+	if f.listener != l {
+		// The listener was closed on purpose, e.g., while re-connecting
+		t.mu.Unlock()
+		return
+	}
+	f.listener = nil
+	t.mu.Unlock()
+	t.ForceReconnect()
+}
+
+// run watches the connected tunnel until disconn is closed, either since
+// the client disconnected or by calling disconnect, and re-connects it.
+func (t *Tunnel) run(disconn chan struct{}, disconnect func()) {
+	t.mu.Lock()
+	c := t.client
+	t.mu.Unlock()
 	go func() {
-		t.client.Wait()
-		close(disconn)
+		c.Wait()
+		disconnect()
 	}()
 
-	go t.waitFor(func() { t.keepAlive(disconn) })
-	go t.waitFor(func() { t.handleConns() })
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		t.keepAlive(c, disconn)
+	}()
+	if t.Health != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t.healthCheck(c, disconn)
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		t.renewCert(c, disconn)
+	}()
 
-	stopped := false
 	select {
 	case <-t.stop:
 		log.Infof("%v: received stop signal", t.Name)
-		stopped = true
-		t.client.Close()
+		t.shutdown()
+		wg.Wait()
+		clients.release(t.conn)
+		t.setStatus(Closed)
+		close(t.Closed)
+		return
 	case <-disconn:
 	}
-	t.listener.Close()
-	t.wg.Wait()
-	if !stopped {
-		if err := t.reconnectLoop(); err != nil {
-			log.Errorf("%v: could not re-connect: %v", t.Name, err)
-		} else {
-			// Successfully re-connected
-			return
-		}
+
+	// Hold new connections until re-connected
+	t.mu.Lock()
+	t.ready = make(chan struct{})
+	t.mu.Unlock()
+	t.closeListeners(true)
+	wg.Wait()
+	clients.release(t.conn)
+
+	err := t.reconnectLoop()
+	if err == nil {
+		// Successfully re-connected
+		return
+	}
+	t.stopOnce.Do(func() { close(t.stop) })
+	t.shutdown()
+	if errors.Is(err, errStopped) {
+		t.setStatus(Closed)
+	} else {
+		// Keep failed tunnels around, showing the error
+		log.Errorf("%v: could not re-connect: %v", t.Name, err)
+		t.setFailed(Failed, err.Error())
 	}
-	t.Status = Closed
 	close(t.Closed)
 }
 
-func (t *Tunnel) keepAlive(cancel chan struct{}) {
-	// panics if nil, this should never happen
-	interv := *t.KeepAlive
+// shutdown closes all listeners and connections of a stopped tunnel
+// and waits for their handlers to return.
+func (t *Tunnel) shutdown() {
+	t.closeListeners(false)
+	t.closeConns()
+	t.wg.Wait()
+}
+
+// keepAlive sends keep-alives to the server. Like ssh(1), the client is
+// closed once the configured number of them went unanswered in a row,
+// which triggers the reconnection logic.
+func (t *Tunnel) keepAlive(c *ssh.Client, cancel chan struct{}) {
+	t.mu.Lock()
+	interv, countMax := t.aliveInterval, t.aliveCountMax
+	t.mu.Unlock()
 
-	if interv == 0 {
+	if interv <= 0 {
 		log.Infof("%v: disabling keep-alives since set to 0", t.Name)
 		return
 	}
 
+	// Requests are answered in order, so at most one is pending
+	var pending chan error
+	var missed int
+	ticker := time.NewTicker(interv)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-cancel:
 			return
-		case <-time.After(time.Duration(interv) * time.Second):
-			_, _, err := t.client.SendRequest("keepalive@golang.org", true, nil)
+		case <-t.stop:
+			return
+		case err := <-pending:
+			pending = nil
 			if err != nil {
 				log.Errorf("%v: error sending keepalive: %v", t.Name, err)
-				// Close the client, this triggers the reconnection logic
-				t.client.Close()
+				c.Close()
 				return
 			}
-			log.Debugf("%v: sent keep-alive", t.Name)
+			missed = 0
+		case <-ticker.C:
+			if pending == nil {
+				pending = make(chan error, 1)
+				go func(p chan error) {
+					_, _, err := c.SendRequest("keepalive@golang.org", true, nil)
+					p <- err
+				}(pending)
+				log.Debugf("%v: sent keep-alive", t.Name)
+				continue
+			}
+			missed++
+			if missed >= countMax {
+				log.Errorf("%v: server did not answer %d keep-alive(s), disconnecting",
+					t.Name, missed)
+				c.Close()
+				return
+			}
+			log.Warningf("%v: server did not answer keep-alive (%d/%d)",
+				t.Name, missed, countMax)
 		}
 	}
 }
 
-func (t *Tunnel) handleConns() {
-	defer t.listener.Close()
-	defer t.client.Close()
-	if t.Mode == Local || t.Mode == Remote {
-		t.handleForward()
+// handleConns serves a single forward until its listener fails
+func (t *Tunnel) handleConns(f *forward, l net.Listener) {
+	defer l.Close()
+	if f.Mode == Local || f.Mode == Remote {
+		t.handleForward(f, l)
 		return
 	}
-	t.handleSocks()
+	t.handleSocks(f, l)
 }
 
-func (t *Tunnel) handleForward() {
+func (t *Tunnel) handleForward(f *forward, l net.Listener) {
 	for {
-		conn1, err := t.listener.Accept()
+		conn1, err := l.Accept()
 		if err != nil {
 			log.Errorf("%v: could not accept: %v", t.Name, err)
 			return
 		}
+		conn1 = t.track(conn1)
 		go t.waitFor(func() {
-			addr := t.remoteAddr
-			if t.Mode == Remote || t.Mode == RemoteSocks {
-				addr = t.localAddr
+			defer t.untrack(conn1)
+			addr := f.remoteAddr
+			if f.isRemote() {
+				addr = f.localAddr
 			}
-			conn2, err := t.dial(addr.net, addr.addr)
+			conn2, err := t.dial(f, addr.net, addr.addr)
 			if err != nil {
 				log.Errorf("%v: could not dial: %v", t.Name, err)
+				t.stats.failedDials.Add(1)
+				conn1.Close()
 				return
 			}
 			tunnel(conn1, conn2)
@@ -321,57 +829,85 @@ func tunnel(c1, c2 net.Conn) {
 	<-done
 }
 
-func (t *Tunnel) handleSocks() {
+func (t *Tunnel) handleSocks(f *forward, l net.Listener) {
 	serv := &proxy.Server{
 		Dialer: func(ctx context.Context, netw, addr string) (net.Conn, error) {
-			return t.dial(netw, addr)
+			c, err := t.dial(f, netw, addr)
+			if err != nil {
+				t.stats.failedDials.Add(1)
+			}
+			return c, err
 		},
 	}
 	for {
-		conn, err := t.listener.Accept()
+		conn, err := l.Accept()
 		if err != nil {
 			log.Errorf("%v: could not accept: %v", t.Name, err)
 			return
 		}
-		go t.waitFor(func() { serv.ServeConn(conn) })
+		conn = t.track(conn)
+		go t.waitFor(func() {
+			defer t.untrack(conn)
+			serv.ServeConn(conn)
+		})
 	}
 }
 
-func (t *Tunnel) reconnectLoop() error {
-	t.Status = Reconn
-	timeout := time.After(reconnectTimeout)
-	wait := time.NewTimer(2 * time.Millisecond) // First time try (essent.) immediately
-	waitTime := initReconnectWait
+func (t *Tunnel) Close() error {
+	if s := t.State(); s == Closed || s == Failed {
+		return fmt.Errorf("trying to close a closed tunnel")
+	}
+	closing := true
+	t.stopOnce.Do(func() {
+		close(t.stop)
+		closing = false
+	})
+	if closing {
+		return ErrClosing
+	}
+	return nil
+}
 
-	for {
-		select {
-		case <-timeout:
-			return fmt.Errorf("re-connect timeout")
-		case <-t.stop:
-			return fmt.Errorf("re-connect interrupted by stop signal")
-		case <-wait.C:
-			log.Infof("%v: try re-connect...", t.Name)
-			err := t.Open()
-			if err == nil {
-				return nil
-			}
-			log.Errorf("%v: could not re-connect: %v. Retrying in %v...",
-				t.Name, err, waitTime)
-			wait.Reset(waitTime)
-			waitTime *= 2
-			if waitTime > maxReconnectWait {
-				waitTime = maxReconnectWait
-			}
-		}
+// track registers an accepted connection and wraps it for counting
+// the bytes it transfers. Tracked connections must be untracked.
+func (t *Tunnel) track(c net.Conn) net.Conn {
+	t.stats.totalConns.Add(1)
+	t.stats.activeConns.Add(1)
+	c = &countConn{Conn: c, s: &t.stats}
+
+	t.connsMu.Lock()
+	defer t.connsMu.Unlock()
+	select {
+	case <-t.stop:
+		// Accepted after closeConns, close right away
+		c.Close()
+		return c
+	default:
+	}
+	if t.conns == nil {
+		t.conns = make(map[net.Conn]struct{})
 	}
+	t.conns[c] = struct{}{}
+	return c
 }
 
-func (t *Tunnel) Close() error {
-	if t.Status == Closed {
-		return fmt.Errorf("trying to close a closed tunnel")
+func (t *Tunnel) untrack(c net.Conn) {
+	t.stats.activeConns.Add(-1)
+	t.stats.lastActive.Store(time.Now().UnixNano())
+	t.connsMu.Lock()
+	delete(t.conns, c)
+	t.connsMu.Unlock()
+	t.resetIdle()
+}
+
+// closeConns closes all accepted connections. Must be called after
+// t.stop is closed.
+func (t *Tunnel) closeConns() {
+	t.connsMu.Lock()
+	defer t.connsMu.Unlock()
+	for c := range t.conns {
+		c.Close()
 	}
-	close(t.stop)
-	return nil
 }
 
 // Logic registered with waitFor will be waited for upon tunnel closing
@@ -0,0 +1,76 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/proxy"
+	"github.com/alebeck/boring/internal/ssh_config"
+	xproxy "golang.org/x/net/proxy"
+)
+
+// noProxy disables upstream proxies, including the one from $ALL_PROXY
+const noProxy = "none"
+
+// proxyDial returns the dial function for connecting to the first hop
+// through the upstream proxy of the tunnel, or through the one given by
+// $ALL_PROXY if the tunnel sets none. It returns nil if the hop is to be
+// dialed directly.
+func (t *Tunnel) proxyDial(hop ssh_config.Hop) (hopDial, error) {
+	var d xproxy.Dialer
+	switch t.Proxy {
+	case noProxy:
+		return nil, nil
+	case "":
+		var err error
+		if d, err = proxy.FromEnvironment(hop.Timeout); err != nil {
+			return nil, err
+		}
+	default:
+		u, err := proxy.ParseURL(t.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		if d, err = proxy.NewDialer(u, hop.Timeout); err != nil {
+			return nil, err
+		}
+	}
+	if d == nil {
+		return nil, nil
+	}
+	return func(network, addr string) (net.Conn, error) {
+		conn, err := d.Dial(network, addr)
+		if err != nil {
+			return nil, fmt.Errorf("via proxy: %v", err)
+		}
+		log.Debugf("%v: connected to %v via proxy", t.Name, addr)
+		return conn, nil
+	}, nil
+}
+
+// validateProxy checks the upstream proxy of the tunnel, if any
+func (t *Tunnel) validateProxy() error {
+	if t.Proxy == "" || t.Proxy == noProxy {
+		return nil
+	}
+	_, err := proxy.ParseURL(t.Proxy)
+	return err
+}
+
+// proxyKey identifies the upstream proxy used by the tunnel for sharing
+// clients, without credentials. Tunnels disabling proxies get their own
+// key, so they don't share clients dialed through the one from $ALL_PROXY.
+func (t *Tunnel) proxyKey() string {
+	switch t.Proxy {
+	case noProxy:
+		return noProxy
+	case "":
+		return proxy.EnvironmentKey()
+	}
+	u, err := proxy.ParseURL(t.Proxy)
+	if err != nil {
+		return ""
+	}
+	return u.Redacted()
+}
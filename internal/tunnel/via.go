@@ -0,0 +1,169 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	xproxy "golang.org/x/net/proxy"
+)
+
+// hopDial dials a hop, e.g., through the previous hop or another tunnel
+type hopDial func(network, addr string) (net.Conn, error)
+
+// Ways of dialing through another tunnel, see ViaMode
+const (
+	// viaSSH dials from the server of the other tunnel, the default
+	viaSSH = "ssh"
+	// viaSocks dials through the SOCKS forward of the other tunnel
+	viaSocks = "socks"
+	// viaForward connects to the local forward of the other tunnel
+	// leading to the first hop
+	viaForward = "forward"
+)
+
+// ValidateVia checks that the tunnel connected through, if any, has a
+// forward of the kind ViaMode dials through
+func (d *Desc) ValidateVia() error {
+	if d.ViaMode != "" && d.ViaTunnel == "" {
+		return fmt.Errorf("via_mode requires via_tunnel")
+	}
+	var mode Mode
+	var kind string
+	switch d.ViaMode {
+	case "", viaSSH:
+		return nil
+	case viaSocks:
+		mode, kind = Socks, "socks"
+	case viaForward:
+		mode, kind = Local, "local"
+	default:
+		return fmt.Errorf("unsupported via_mode '%v', expected %v, %v or %v",
+			d.ViaMode, viaSSH, viaSocks, viaForward)
+	}
+	if d.Via == nil {
+		return nil
+	}
+	for _, f := range d.Via.AllForwards() {
+		if f.Mode == mode {
+			return nil
+		}
+	}
+	return fmt.Errorf("via_mode %v requires tunnel '%v' to have a %v forward",
+		d.ViaMode, d.ViaTunnel, kind)
+}
+
+// SetVia sets the running tunnel through which the first hop is dialed,
+// see ViaTunnel.
+func (t *Tunnel) SetVia(via *Tunnel) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.via = via
+}
+
+// viaDial returns the dial function of the tunnel set via SetVia, or
+// nil if the first hop is dialed directly
+func (t *Tunnel) viaDial() hopDial {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case t.via == nil:
+		return nil
+	case t.ViaMode == viaSocks:
+		return t.via.dialSocks
+	case t.ViaMode == viaForward:
+		return t.via.dialForward
+	}
+	return t.via.dialThrough
+}
+
+// dialThrough dials addr from the server of the tunnel, like its forwards
+// do. While re-connecting, dials are held like connections to the forwards,
+// lazy tunnels connect on demand. The connection counts as an active one
+// of the tunnel, so lazy tunnels stay connected while it is open.
+func (t *Tunnel) dialThrough(network, addr string) (net.Conn, error) {
+	var err error
+	var conn net.Conn
+	if t.Lazy {
+		conn, err = t.lazyDial(network, addr)
+	} else if c, werr := t.waitClient(); werr != nil {
+		err = werr
+	} else {
+		conn, err = c.Dial(network, addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("via tunnel %v: %v", t.Name, err)
+	}
+	return &viaConn{Conn: t.track(conn), t: t}, nil
+}
+
+// dialSocks dials addr through the SOCKS forward of the tunnel. Like other
+// clients of the forward, the dial is held while re-connecting.
+func (t *Tunnel) dialSocks(network, addr string) (net.Conn, error) {
+	f, l, err := t.listenerOf(func(f *forward) bool { return f.Mode == Socks })
+	if err != nil {
+		return nil, err
+	}
+	d, err := xproxy.SOCKS5(f.localAddr.net, l.String(), nil, xproxy.Direct)
+	if err != nil {
+		return nil, fmt.Errorf("via tunnel %v: %v", t.Name, err)
+	}
+	conn, err := d.Dial(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("via tunnel %v: %v", t.Name, err)
+	}
+	return conn, nil
+}
+
+// dialForward connects to the local forward of the tunnel leading to
+// addr, or to its only local forward if none has addr as its remote
+// address
+func (t *Tunnel) dialForward(_, addr string) (net.Conn, error) {
+	var locals int
+	for _, f := range t.forwards {
+		if f.Mode == Local {
+			locals++
+		}
+	}
+	f, l, err := t.listenerOf(func(f *forward) bool {
+		return f.Mode == Local && (f.remoteAddr.addr == addr || locals == 1)
+	})
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial(f.localAddr.net, l.String())
+	if err != nil {
+		return nil, fmt.Errorf("via tunnel %v: %v", t.Name, err)
+	}
+	return conn, nil
+}
+
+// listenerOf returns the first forward of the tunnel matching fn, along
+// with the address it listens on
+func (t *Tunnel) listenerOf(fn func(*forward) bool) (*forward, net.Addr, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, f := range t.forwards {
+		if !fn(f) {
+			continue
+		}
+		if f.listener == nil {
+			return nil, nil, fmt.Errorf("via tunnel %v: forward %v is not listening", t.Name, f.Forward)
+		}
+		return f, f.listener.Addr(), nil
+	}
+	return nil, nil, fmt.Errorf("via tunnel %v: no matching forward", t.Name)
+}
+
+// viaConn is a connection dialed through a tunnel, untracked once closed
+type viaConn struct {
+	net.Conn
+	t    *Tunnel
+	once sync.Once
+}
+
+func (c *viaConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { c.t.untrack(c.Conn) })
+	return err
+}
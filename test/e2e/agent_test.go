@@ -1,6 +1,9 @@
 package e2e
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -83,3 +86,94 @@ func TestAgentIdsOnly(t *testing.T) {
 		t.Fatalf("exit code %d: %s", c, out)
 	}
 }
+
+func TestIdentityAgent(t *testing.T) {
+	dir := t.TempDir()
+	sock := filepath.Join(dir, "other-agent.sock")
+	cancelAgent, err := startAgent(sock)
+	if err != nil {
+		t.Fatalf("could not start agent: %v", err)
+	}
+	defer cancelAgent()
+
+	for _, tc := range []struct {
+		identityAgent string
+		useAgent      bool
+		success       bool
+	}{
+		// Agent configured per host, $SSH_AUTH_SOCK does not exist
+		{sock, false, true},
+		// Agent at $SSH_AUTH_SOCK is not used with none
+		{"none", true, false},
+	} {
+		cfg := defaultConfig
+		cfg.useAgent = tc.useAgent
+		cfg.sshConfig = filepath.Join(t.TempDir(), "ssh_config")
+		conf := fmt.Sprintf(`Match all
+    IdentityAgent %s
+    Port 58391
+    UserKnownHostsFile ../testdata/known_hosts/known_hosts
+`, tc.identityAgent)
+		if err := os.WriteFile(cfg.sshConfig, []byte(conf), 0600); err != nil {
+			t.Fatal(err)
+		}
+		env, cancel, err := makeEnvWithDaemon(cfg, t)
+		if err != nil {
+			t.Fatalf("%v", err.Error())
+		}
+		if tc.useAgent {
+			cancelDefault, err := startAgent(getEnv(env, "SSH_AUTH_SOCK"))
+			if err != nil {
+				t.Fatalf("could not start agent: %v", err)
+			}
+			defer cancelDefault()
+		}
+
+		c, out, err := cliCommand(env, "open", "test")
+		cancel()
+		if err != nil {
+			t.Fatalf("failed to run CLI command: %v", err)
+		}
+		if (c == 0) != tc.success {
+			t.Errorf("IdentityAgent %v: exit code %d: %s", tc.identityAgent, c, out)
+		}
+	}
+}
+
+// Several agents given in the boring config are all used
+func TestIdentityAgentMultiple(t *testing.T) {
+	dir := t.TempDir()
+	sock := filepath.Join(dir, "agent.sock")
+	cancelAgent, err := startAgent(sock)
+	if err != nil {
+		t.Fatalf("could not start agent: %v", err)
+	}
+	defer cancelAgent()
+
+	cfg := defaultConfig
+	cfg.sshConfig = "../testdata/config/ssh_config_no_id"
+	cfg.boringConfig = filepath.Join(dir, "config.toml")
+	conf := fmt.Sprintf(`[[tunnels]]
+name = "test"
+host = "127.0.0.1"
+local = "localhost:49711"
+remote = "localhost:49712"
+identity_agent = ["%s", "%s"]
+`, filepath.Join(dir, "missing.sock"), sock)
+	if err := os.WriteFile(cfg.boringConfig, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+}
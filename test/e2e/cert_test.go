@@ -0,0 +1,132 @@
+package e2e
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// writeCert issues a user certificate for the client key, valid for the
+// given time
+func writeCert(t *testing.T, path string, valid time.Duration) {
+	ca, err := loadHostKey(caPrivKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := loadAuthorizedKey(authorizedKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{
+		Key:             pub,
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"needs-cert"},
+		ValidAfter:      uint64(time.Now().Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(time.Now().Add(valid).Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, ssh.MarshalAuthorizedKey(cert), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// certEnv opens a tunnel authenticating with a certificate which is valid
+// for the given time. If renewed is given, the renewal command copies it
+// in place of the certificate.
+func certEnv(t *testing.T, valid time.Duration, renewed string) ([]string, func()) {
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "cert.pub")
+	writeCert(t, cert, valid)
+	var command string
+	if renewed != "" {
+		command = fmt.Sprintf("cp %s %s", renewed, cert)
+	}
+
+	cfg := defaultConfig
+	cfg.sshConfig = filepath.Join(dir, "ssh_config")
+	conf := fmt.Sprintf(`Host *
+    User needs-cert
+    Port 58391
+    IdentityFile ../testdata/keys/client
+    CertificateFile %s
+    UserKnownHostsFile ../testdata/known_hosts/known_hosts
+`, cert)
+	if err := os.WriteFile(cfg.sshConfig, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg.boringConfig = filepath.Join(dir, "config.toml")
+	conf = fmt.Sprintf(`[[tunnels]]
+name = "test"
+host = "127.0.0.1"
+local = "localhost:49711"
+remote = "localhost:49712"
+cert_renew_command = "%s"
+cert_renew_before = "4s"
+`, command)
+	if err := os.WriteFile(cfg.boringConfig, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	c, out, err := cliCommand(env, "open", "test")
+	if err != nil {
+		cancel()
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		cancel()
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	return env, cancel
+}
+
+func TestCertExpiryWarning(t *testing.T) {
+	env, cancel := certEnv(t, 3*time.Second, "")
+	defer cancel()
+
+	c, out, err := cliCommand(env, "list")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 || !strings.Contains(out, "certificate expires in") {
+		t.Errorf("expected expiry warning, exit code %d: %s", c, out)
+	}
+}
+
+func TestCertRenewal(t *testing.T) {
+	renewed := filepath.Join(t.TempDir(), "renewed.pub")
+	writeCert(t, renewed, time.Hour)
+	env, cancel := certEnv(t, 6*time.Second, renewed)
+	defer cancel()
+
+	// Renewed and re-connected before expiry
+	time.Sleep(3500 * time.Millisecond)
+	logs, err := os.ReadFile(getEnv(env, "BORING_LOG_FILE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(logs), "certificate renewed") {
+		t.Errorf("certificate not renewed: %s", logs)
+	}
+	c, out, err := cliCommand(env, "list")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 || strings.Contains(out, "certificate expires") {
+		t.Errorf("unexpected expiry warning, exit code %d: %s", c, out)
+	}
+	// Still connected once the original certificate expired
+	time.Sleep(3 * time.Second)
+	testTunnel(t, "localhost:49711", "localhost:49712")
+}
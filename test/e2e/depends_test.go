@@ -0,0 +1,146 @@
+package e2e
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dependsEnv runs a daemon with tunnel "app" depending on "dep", which
+// re-connects every few seconds since its server does not answer keep-alives.
+// Tunnel "other" shares the client of "app".
+func dependsEnv(t *testing.T) []string {
+	cfg := defaultConfig
+	cfg.boringConfig = filepath.Join(t.TempDir(), "config.toml")
+	conf := `keep_alive = 0
+
+[[tunnels]]
+name = "app"
+host = "127.0.0.1"
+local = "localhost:49711"
+remote = "localhost:49712"
+depends_on = ["dep"]
+
+[[tunnels]]
+name = "other"
+host = "127.0.0.1"
+local = "localhost:49717"
+remote = "localhost:49712"
+
+[[tunnels]]
+name = "dep"
+host = "127.0.0.1"
+user = "deaf"
+local = "localhost:49713"
+remote = "localhost:49714"
+keep_alive = 1
+keep_alive_count_max = 1
+`
+	if err := os.WriteFile(cfg.boringConfig, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	t.Cleanup(cancel)
+	return env
+}
+
+func TestDependsOnOrder(t *testing.T) {
+	env := dependsEnv(t)
+
+	// Without ordering, the daemon would open "dep" along with "app",
+	// and report it as already running
+	c, out, err := cliCommand(env, "open", "app", "dep")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	out = stripANSI(out)
+	i, j := strings.Index(out, "Opened tunnel 'dep'"), strings.Index(out, "Opened tunnel 'app'")
+	if i < 0 || j < i {
+		t.Errorf("tunnels not opened in order: %s", out)
+	}
+
+	c, out, err = cliCommand(env, "close", "app", "dep")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+}
+
+func TestDependsOnCascade(t *testing.T) {
+	env := dependsEnv(t)
+
+	// Dependencies are opened first
+	c, out, err := cliCommand(env, "open", "app")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	testTunnel(t, "localhost:49713", "localhost:49714")
+
+	// Re-connects are cascaded to dependents
+	time.Sleep(3 * time.Second)
+	logs, err := os.ReadFile(getEnv(env, "BORING_LOG_FILE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(logs), "app: opened tunnel dep it depends on") {
+		t.Errorf("dependency not opened first: %s", logs)
+	}
+	if !strings.Contains(string(logs), "app: tunnel dep it depends on re-connected") {
+		t.Errorf("re-connect not cascaded: %s", logs)
+	}
+	testTunnel(t, "localhost:49711", "localhost:49712")
+
+	// Closes are cascaded to dependents
+	if c, out, err = cliCommand(env, "close", "dep"); err != nil || c != 0 {
+		t.Fatalf("could not close tunnel: %v, %s", err, out)
+	}
+	_, out, err = cliCommand(env, "list")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	for _, l := range strings.Split(stripANSI(out), "\n") {
+		if f := strings.Fields(l); len(f) > 1 && f[1] == "app" && f[0] != "closed" {
+			t.Errorf("dependent tunnel not closed: %s", out)
+		}
+	}
+}
+
+func TestDependsOnSharedClient(t *testing.T) {
+	env := dependsEnv(t)
+
+	c, out, err := cliCommand(env, "open", "app", "other")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	// Re-connecting "app" leaves the client it shares with "other" open
+	time.Sleep(3 * time.Second)
+	logs, err := os.ReadFile(getEnv(env, "BORING_LOG_FILE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(logs), "app: tunnel dep it depends on re-connected") {
+		t.Errorf("re-connect not cascaded: %s", logs)
+	}
+	if strings.Contains(string(logs), "other: try re-connect") {
+		t.Errorf("tunnel sharing the client re-connected: %s", logs)
+	}
+	testTunnel(t, "localhost:49711", "localhost:49712")
+	testTunnel(t, "localhost:49717", "localhost:49712")
+}
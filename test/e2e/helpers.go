@@ -60,6 +60,12 @@ func makeEnv(c config, t *testing.T) ([]string, error) {
 		"BORING_SSH_CONFIG="+c.sshConfig,
 		"BORING_COMMIT_OVERRIDE="+c.commitOverride,
 		"BORING_TAG_OVERRIDE="+c.tagOverride,
+		// Don't pick up askpass programs of the test environment
+		"BORING_ASKPASS=",
+		"SSH_ASKPASS=",
+		// Nor proxies
+		"ALL_PROXY=",
+		"all_proxy=",
 	)
 
 	if c.noSpawn {
@@ -158,10 +164,18 @@ func makeDefaultEnvWithDaemon(t *testing.T) ([]string, context.CancelFunc, error
 }
 
 func cliCommand(env []string, cmds ...string) (int, string, error) {
+	return cliCommandWithInput(env, "", cmds...)
+}
+
+// cliCommandWithInput runs a CLI command, passing input on stdin
+func cliCommandWithInput(env []string, input string, cmds ...string) (int, string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), cliTimeout)
 	defer cancel()
 	cmd := exec.CommandContext(ctx, binary, cmds...)
 	cmd.Env = env
+	if input != "" {
+		cmd.Stdin = strings.NewReader(input)
+	}
 	output, err := cmd.CombinedOutput()
 	if exitErr, ok := err.(*exec.ExitError); ok {
 		return exitErr.ExitCode(), string(output), nil
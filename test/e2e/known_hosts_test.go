@@ -0,0 +1,290 @@
+package e2e
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeSSHConfig writes an SSH config using a temporary known hosts file,
+// and returns the paths of both
+func writeSSHConfig(t *testing.T, extra string) (string, string) {
+	dir := t.TempDir()
+	kh := filepath.Join(dir, "known_hosts")
+	// Extra options come first, so they take precedence
+	conf := fmt.Sprintf(`Match all
+%s    HostName 127.0.0.1
+    Port 58391
+    User test
+    IdentityFile ../testdata/keys/client
+    UserKnownHostsFile %s
+`, extra, kh)
+	sc := filepath.Join(dir, "ssh_config")
+	if err := os.WriteFile(sc, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return sc, kh
+}
+
+func TestAcceptNew(t *testing.T) {
+	cfg := defaultConfig
+	var kh string
+	cfg.sshConfig, kh = writeSSHConfig(t,
+		"    StrictHostKeyChecking accept-new\n    HashKnownHosts yes\n")
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	testTunnel(t, "localhost:49711", "localhost:49712")
+
+	b, err := os.ReadFile(kh)
+	if err != nil {
+		t.Fatalf("known hosts file not written: %v", err)
+	}
+	if !strings.HasPrefix(string(b), "|1|") || !strings.Contains(string(b), "ssh-ed25519") {
+		t.Errorf("expected hashed ed25519 entry, got %s", b)
+	}
+
+	if c, out, err = cliCommand(env, "close", "test"); err != nil || c != 0 {
+		t.Fatalf("could not close tunnel: %v, %s", err, out)
+	}
+
+	// A changed key is rejected
+	pub, err := os.ReadFile("../testdata/keys/client.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(string(pub))
+	line := fmt.Sprintf("[127.0.0.1]:58391 %s %s\n", fields[0], fields[1])
+	if err := os.WriteFile(kh, []byte(line), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, out, err = cliCommand(env, "open", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c == 0 {
+		t.Fatalf("changed host key was accepted: %s", out)
+	}
+	if !strings.Contains(out, "HAS CHANGED") || !strings.Contains(out, kh+":1") {
+		t.Errorf("mismatch was not explained: %s", out)
+	}
+}
+
+func TestTrust(t *testing.T) {
+	cfg := defaultConfig
+	var kh string
+	cfg.sshConfig, kh = writeSSHConfig(t, "")
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	// Unknown host is rejected
+	c, out, err := cliCommand(env, "open", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c == 0 {
+		t.Fatalf("unknown host was accepted: %s", out)
+	}
+
+	// Declining does not add the key
+	c, out, err = cliCommandWithInput(env, "no\n", "trust", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c == 0 {
+		t.Fatalf("expected failure when declining: %s", out)
+	}
+	if _, err := os.Stat(kh); err == nil {
+		t.Fatalf("key was added despite declining")
+	}
+
+	c, out, err = cliCommandWithInput(env, "yes\n", "trust", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	if !strings.Contains(out, "SHA256:") {
+		t.Errorf("fingerprint was not shown: %s", out)
+	}
+
+	c, out, err = cliCommand(env, "open", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	testTunnel(t, "localhost:49711", "localhost:49712")
+
+	// Hosts reached via another tunnel are not dialed directly
+	c, out, err = cliCommandWithInput(env, "yes\n", "trust", "test-via")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c == 0 || !strings.Contains(out, "via tunnel") {
+		t.Errorf("expected via tunnel to be refused, exit code %d: %s", c, out)
+	}
+}
+
+func TestPinnedHostKeys(t *testing.T) {
+	// No known hosts file exists
+	cfg := defaultConfig
+	cfg.sshConfig, _ = writeSSHConfig(t, "")
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	for _, name := range []string{"test-pinned-key", "test-pinned-fp", "test-pinned-ca"} {
+		c, out, err := cliCommand(env, "open", name)
+		if err != nil {
+			t.Fatalf("failed to run CLI command: %v", err)
+		}
+		if c != 0 {
+			t.Fatalf("%v: exit code %d: %s", name, c, out)
+		}
+		testTunnel(t, "localhost:49711", "localhost:49712")
+		if c, out, err = cliCommand(env, "close", name); err != nil || c != 0 {
+			t.Fatalf("could not close tunnel: %v, %s", err, out)
+		}
+	}
+
+	c, out, err := cliCommand(env, "open", "test-pinned-wrong")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c == 0 || !strings.Contains(out, "does not match pinned keys") {
+		t.Fatalf("expected mismatch, got %d: %s", c, out)
+	}
+}
+
+func TestKnownHostsCommands(t *testing.T) {
+	cfg := defaultConfig
+	var kh string
+	cfg.sshConfig, kh = writeSSHConfig(t, "")
+	env, err := makeEnv(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+
+	server, err := os.ReadFile("../testdata/known_hosts/known_hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := "other.example.com ssh-ed25519 " +
+		"AAAAC3NzaC1lZDI1NTE5AAAAIN06LnpTGJSS8Q/hUJr2IqcJ5vEbosXHBxLnX1Ja+wDE\n" + string(server)
+	if err := os.WriteFile(kh, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, out, err := cliCommand(env, "known-hosts", "list")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 || !strings.Contains(out, "other.example.com") || !strings.Contains(out, "[127.0.0.1]:58391") {
+		t.Fatalf("unexpected output %d: %s", c, out)
+	}
+
+	// The host is resolved via SSH config
+	c, out, err = cliCommand(env, "known-hosts", "list", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 || strings.Contains(out, "other.example.com") ||
+		!strings.Contains(out, "SHA256:J5ZSKbQ4iUGfm3AR0Ts5E8md2ppIr5vCvSDTk2xHm5g") {
+		t.Fatalf("unexpected output %d: %s", c, out)
+	}
+
+	c, out, err = cliCommand(env, "known-hosts", "remove", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	b, err := os.ReadFile(kh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "58391") || !strings.Contains(string(b), "other.example.com") {
+		t.Errorf("unexpected known hosts after removal: %s", b)
+	}
+
+	// Nothing left to remove
+	c, out, err = cliCommand(env, "known-hosts", "remove", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c == 0 {
+		t.Errorf("expected failure: %s", out)
+	}
+}
+
+func TestUpdateHostKeys(t *testing.T) {
+	server, err := os.ReadFile("../testdata/known_hosts/known_hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A deprecated key, which the server does not announce anymore
+	stale := "[127.0.0.1]:58391 ssh-ed25519 " +
+		"AAAAC3NzaC1lZDI1NTE5AAAAIN06LnpTGJSS8Q/hUJr2IqcJ5vEbosXHBxLnX1Ja+wDE\n"
+
+	for _, update := range []string{"yes", "no"} {
+		cfg := defaultConfig
+		var kh string
+		cfg.sshConfig, kh = writeSSHConfig(t,
+			fmt.Sprintf("    User rotate\n    UpdateHostKeys %s\n", update))
+		if err := os.WriteFile(kh, append([]byte(stale), server...), 0600); err != nil {
+			t.Fatal(err)
+		}
+		env, cancel, err := makeEnvWithDaemon(cfg, t)
+		if err != nil {
+			t.Fatalf("%v", err.Error())
+		}
+
+		c, out, err := cliCommand(env, "open", "test")
+		if err != nil {
+			t.Fatalf("failed to run CLI command: %v", err)
+		}
+		if c != 0 {
+			t.Fatalf("exit code %d: %s", c, out)
+		}
+		time.Sleep(200 * time.Millisecond)
+		cancel()
+
+		b, err := os.ReadFile(kh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+		if update == "no" {
+			if string(b) != stale+string(server) {
+				t.Errorf("known hosts changed despite UpdateHostKeys no: %s", b)
+			}
+			continue
+		}
+		if len(lines) != 2 || strings.Contains(string(b), stale) ||
+			!strings.Contains(string(b), strings.TrimSpace(string(server))) {
+			t.Errorf("unexpected known hosts after update: %s", b)
+		}
+	}
+}
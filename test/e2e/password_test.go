@@ -0,0 +1,116 @@
+package e2e
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func makePasswordEnv(t *testing.T, sshConfig string, askpass bool) ([]string, func()) {
+	cfg := defaultConfig
+	cfg.sshConfig = sshConfig
+	env, err := makeEnv(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	if askpass {
+		p, err := filepath.Abs("../testdata/askpass/askpass.sh")
+		if err != nil {
+			t.Fatalf("%v", err.Error())
+		}
+		env = append(env, "BORING_ASKPASS="+p)
+	}
+	cancel, err := daemonWithCancel(env)
+	if err != nil {
+		t.Fatalf("could not start daemon: %v", err)
+	}
+	return env, cancel
+}
+
+func TestPasswordAskpass(t *testing.T) {
+	env, cancel := makePasswordEnv(t, "../testdata/config/ssh_config_password", true)
+	defer cancel()
+
+	for _, name := range []string{"test-password", "test-otp"} {
+		c, out, err := cliCommand(env, "open", name)
+		if err != nil {
+			t.Fatalf("failed to run CLI command: %v", err)
+		}
+		if c != 0 {
+			t.Fatalf("exit code %d: %s", c, out)
+		}
+
+		testTunnel(t, "localhost:49711", "localhost:49712")
+
+		c, out, err = cliCommand(env, "close", name)
+		if err != nil {
+			t.Fatalf("failed to run CLI command: %v", err)
+		}
+		if c != 0 {
+			t.Fatalf("exit code %d: %s", c, out)
+		}
+	}
+}
+
+func TestPasswordNoPrompt(t *testing.T) {
+	env, cancel := makePasswordEnv(t, "../testdata/config/ssh_config_password", false)
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test-password")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 1 || !strings.Contains(out, "no supported authentication methods") {
+		t.Errorf("expected authentication failure, got %d: %s", c, out)
+	}
+}
+
+func TestPassphraseCached(t *testing.T) {
+	cfg := defaultConfig
+	cfg.sshConfig = "../testdata/config/ssh_config_enc"
+	env, err := makeEnv(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	p, err := filepath.Abs("../testdata/askpass/askpass.sh")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	askpassLog := filepath.Join(t.TempDir(), "askpass.log")
+	env = append(env, "BORING_ASKPASS="+p, "ASKPASS_LOG="+askpassLog)
+	cancel, err := daemonWithCancel(env)
+	if err != nil {
+		t.Fatalf("could not start daemon: %v", err)
+	}
+	defer cancel()
+
+	// The passphrase is only asked for once
+	for range 2 {
+		c, out, err := cliCommand(env, "open", "test")
+		if err != nil {
+			t.Fatalf("failed to run CLI command: %v", err)
+		}
+		if c != 0 {
+			t.Fatalf("exit code %d: %s", c, out)
+		}
+
+		testTunnel(t, "localhost:49711", "localhost:49712")
+
+		c, out, err = cliCommand(env, "close", "test")
+		if err != nil {
+			t.Fatalf("failed to run CLI command: %v", err)
+		}
+		if c != 0 {
+			t.Fatalf("exit code %d: %s", c, out)
+		}
+	}
+
+	b, err := os.ReadFile(askpassLog)
+	if err != nil {
+		t.Fatalf("could not read askpass log: %v", err)
+	}
+	if n := strings.Count(string(b), "Enter passphrase"); n != 1 {
+		t.Errorf("expected one passphrase prompt, got %d: %s", n, b)
+	}
+}
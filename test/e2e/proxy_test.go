@@ -0,0 +1,124 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/proxy"
+)
+
+// socksProxy runs a SOCKS5 proxy requiring credentials and counts the
+// connections made through it
+func socksProxy(t *testing.T) (string, *atomic.Int32) {
+	// Needed by the proxy server
+	log.Init(io.Discard, false, false)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	var n atomic.Int32
+	s := &proxy.Server{
+		Username: "user",
+		Password: "pass",
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			n.Add(1)
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+	go s.Serve(l)
+	return l.Addr().String(), &n
+}
+
+func proxyEnv(t *testing.T, proxy string) []string {
+	cfg := defaultConfig
+	cfg.boringConfig = filepath.Join(t.TempDir(), "config.toml")
+	conf := fmt.Sprintf(`proxy = "%s"
+
+[[tunnels]]
+name = "test"
+host = "127.0.0.1"
+local = "localhost:49711"
+remote = "localhost:49712"
+`, proxy)
+	if err := os.WriteFile(cfg.boringConfig, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	t.Cleanup(cancel)
+	return env
+}
+
+func TestTunnelProxy(t *testing.T) {
+	addr, n := socksProxy(t)
+	env := proxyEnv(t, "socks5://user:pass@"+addr)
+
+	c, out, err := cliCommand(env, "open", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	testTunnel(t, "localhost:49711", "localhost:49712")
+	if n.Load() != 1 {
+		t.Errorf("expected one connection through proxy, got %d", n.Load())
+	}
+}
+
+func TestTunnelProxyRefused(t *testing.T) {
+	addr, n := socksProxy(t)
+	env := proxyEnv(t, "socks5://user:wrong@"+addr)
+
+	c, out, err := cliCommand(env, "open", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c == 0 {
+		t.Fatalf("opened tunnel despite wrong proxy credentials: %s", out)
+	}
+	if !strings.Contains(out, "via proxy") {
+		t.Errorf("output did not indicate proxy error: %s", out)
+	}
+	if n.Load() != 0 {
+		t.Errorf("expected no connection through proxy, got %d", n.Load())
+	}
+}
+
+func TestTunnelProxyEnvironment(t *testing.T) {
+	addr, n := socksProxy(t)
+	env, err := makeEnv(defaultConfig, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	env = setEnv(env, "ALL_PROXY", "socks5://user:pass@"+addr)
+	cancel, err := daemonWithCancel(env)
+	if err != nil {
+		t.Fatalf("could not start daemon: %v", err)
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	testTunnel(t, "localhost:49711", "localhost:49712")
+	if n.Load() != 1 {
+		t.Errorf("expected one connection through proxy, got %d", n.Load())
+	}
+}
@@ -1,8 +1,10 @@
 package e2e
 
 import (
+	"os/exec"
 	"strings"
 	"testing"
+	"time"
 )
 
 // Test that some simple "%" substitutions are working.
@@ -31,3 +33,40 @@ func TestSubstitutions(t *testing.T) {
 func TestRSA(t *testing.T) {
 	// TODO
 }
+
+func TestProxyCommand(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("requires bash")
+	}
+	cfg := defaultConfig
+	cfg.sshConfig = "../testdata/config/ssh_config_proxycmd"
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	testTunnel(t, "localhost:49711", "localhost:49712")
+
+	c, out, err = cliCommand(env, "close", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	// The proxy command is terminated with the connection
+	time.Sleep(100 * time.Millisecond)
+	if n := server.numConns(); n != 0 {
+		t.Errorf("expected no connections, got %d", n)
+	}
+}
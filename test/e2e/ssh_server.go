@@ -1,6 +1,7 @@
 package e2e
 
 import (
+	"crypto/ed25519"
 	"crypto/rand"
 	"fmt"
 	"io"
@@ -18,6 +19,8 @@ const (
 	authorizedKeyFile = "../testdata/keys/client.pub"
 	caKeyFile         = "../testdata/keys/ca.pub"
 	caPrivKeyFile     = "../testdata/keys/ca"
+	testPassword      = "secret"
+	testOTP           = "123456"
 )
 
 type tcpipForwardRequest struct {
@@ -68,6 +71,9 @@ type sshServer struct {
 	// these record received keep-alives
 	keepAliveMu sync.Mutex
 	keepAlives  int
+
+	// hostKeys are announced to clients logging in as user "rotate"
+	hostKeys []ssh.Signer
 }
 
 func startServer() (s *sshServer, err error) {
@@ -123,6 +129,27 @@ func startServer() (s *sshServer, err error) {
 	s = &sshServer{}
 	s.config = &ssh.ServerConfig{
 		PublicKeyCallback: checker.Authenticate,
+		PasswordCallback: func(conn ssh.ConnMetadata, pw []byte) (*ssh.Permissions, error) {
+			if conn.User() == "password" && string(pw) == testPassword {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("wrong password")
+		},
+		KeyboardInteractiveCallback: func(conn ssh.ConnMetadata,
+			client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			if conn.User() != "otp" {
+				return nil, fmt.Errorf("unauthorized")
+			}
+			ans, err := client("", "Please authenticate.",
+				[]string{"Password: ", "Verification code: "}, []bool{false, true})
+			if err != nil {
+				return nil, err
+			}
+			if len(ans) != 2 || ans[0] != testPassword || ans[1] != testOTP {
+				return nil, fmt.Errorf("wrong answers")
+			}
+			return nil, nil
+		},
 	}
 
 	s.conns = make(map[net.Conn]struct{})
@@ -132,6 +159,17 @@ func startServer() (s *sshServer, err error) {
 	s.config.AddHostKey(hostKey)
 	s.config.AddHostKey(certSigner)
 
+	// an additional host key, which is only announced
+	_, newPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	newKey, err := ssh.NewSignerFromKey(newPriv)
+	if err != nil {
+		return nil, err
+	}
+	s.hostKeys = []ssh.Signer{hostKey, newKey}
+
 	s.listener, err = net.Listen("tcp", loopBack)
 	if err != nil {
 		return nil, fmt.Errorf("failed to listen for connection: %v", err)
@@ -175,20 +213,42 @@ func (s *sshServer) handleConn(conn net.Conn) {
 		return
 	}
 
+	if c.User() == "rotate" {
+		go s.announceHostKeys(c)
+	}
+
 	go func() {
 		for req := range reqs {
-			if req.Type == "tcpip-forward" {
+			if req.Type == "hostkeys-prove-00@openssh.com" {
+				s.proveHostKeys(c, req)
+			} else if req.Type == "tcpip-forward" {
 				// parse payload, reply true
 				var payload tcpipForwardRequest
 				if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
 					req.Reply(false, nil)
 					return
 				}
-				req.Reply(true, nil)
-				go listenAndForward(c, payload)
+				l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", payload.Addr, payload.Port))
+				if err != nil {
+					fmt.Printf("failed to listen on %s:%d: %v\n", payload.Addr, payload.Port, err)
+					req.Reply(false, nil)
+					continue
+				}
+				// Report the allocated port if requested
+				var resp []byte
+				if payload.Port == 0 {
+					payload.Port = uint32(l.Addr().(*net.TCPAddr).Port)
+					resp = ssh.Marshal(struct{ Port uint32 }{payload.Port})
+				}
+				req.Reply(true, resp)
+				go listenAndForward(c, l, payload)
 			} else {
 				if req.Type == "keepalive@golang.org" {
 					s.incrementKeepAlives()
+					// Simulate an unresponsive server
+					if c.User() == "deaf" {
+						continue
+					}
 				}
 				req.Reply(false, nil)
 			}
@@ -209,7 +269,55 @@ func (s *sshServer) handleConn(conn net.Conn) {
 	}
 }
 
-func listenAndForward(c *ssh.ServerConn, req tcpipForwardRequest) {
+// announceHostKeys sends the server's host keys, like OpenSSH's sshd
+func (s *sshServer) announceHostKeys(c *ssh.ServerConn) {
+	var payload []byte
+	for _, k := range s.hostKeys {
+		payload = append(payload, ssh.Marshal(struct{ Key []byte }{k.PublicKey().Marshal()})...)
+	}
+	c.SendRequest("hostkeys-00@openssh.com", false, payload)
+}
+
+// proveHostKeys signs the session ID with each of the requested host keys
+func (s *sshServer) proveHostKeys(c *ssh.ServerConn, req *ssh.Request) {
+	var resp []byte
+	rest := req.Payload
+	for len(rest) > 0 {
+		var k struct {
+			Key  []byte
+			Rest []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(rest, &k); err != nil {
+			req.Reply(false, nil)
+			return
+		}
+		rest = k.Rest
+		var signer ssh.Signer
+		for _, h := range s.hostKeys {
+			if string(h.PublicKey().Marshal()) == string(k.Key) {
+				signer = h
+			}
+		}
+		if signer == nil {
+			req.Reply(false, nil)
+			return
+		}
+		data := ssh.Marshal(struct {
+			Type      string
+			SessionID []byte
+			Key       []byte
+		}{"hostkeys-prove-00@openssh.com", c.SessionID(), k.Key})
+		sig, err := signer.Sign(rand.Reader, data)
+		if err != nil {
+			req.Reply(false, nil)
+			return
+		}
+		resp = append(resp, ssh.Marshal(struct{ Sig []byte }{ssh.Marshal(sig)})...)
+	}
+	req.Reply(true, resp)
+}
+
+func listenAndForward(c *ssh.ServerConn, l net.Listener, req tcpipForwardRequest) {
 	remote := c.RemoteAddr().(*net.TCPAddr)
 	payload := ssh.Marshal(forwardedTCPPayload{
 		Addr:       req.Addr,
@@ -218,11 +326,6 @@ func listenAndForward(c *ssh.ServerConn, req tcpipForwardRequest) {
 		OriginPort: uint32(remote.Port),
 	})
 
-	l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", req.Addr, req.Port))
-	if err != nil {
-		fmt.Printf("failed to listen on %s:%d: %v\n", req.Addr, req.Port, err)
-		return
-	}
 	defer l.Close()
 
 	// Close the listener when the server connection is closed
@@ -319,3 +422,9 @@ func (s *sshServer) incrementKeepAlives() {
 	defer s.keepAliveMu.Unlock()
 	s.keepAlives += 1
 }
+
+func (s *sshServer) numConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
@@ -3,9 +3,11 @@ package e2e
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"strings"
@@ -747,6 +749,68 @@ func TestTunnelReconnect(t *testing.T) {
 	testTunnel(t, "localhost:49711", "localhost:49712")
 }
 
+// Re-connects pick up changes of the SSH config, and fall back to the
+// previous configuration if it cannot be parsed anymore
+func TestTunnelReconnectResolve(t *testing.T) {
+	cfg := defaultConfig
+	cfg.sshConfig = filepath.Join(t.TempDir(), "ssh_config")
+	writeConf := func(extra string) {
+		conf := `Match final all
+` + extra + `    User test
+    Port 58391
+    IdentityFile ../testdata/keys/client
+    UserKnownHostsFile ../testdata/known_hosts/known_hosts
+`
+		if err := os.WriteFile(cfg.sshConfig, []byte(conf), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reconnect := func() {
+		server.pause()
+		server.closeAll()
+		server.resume()
+		time.Sleep(500 * time.Millisecond)
+	}
+	writeConf("")
+
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// Invalid config, the previous one is used
+	writeConf("    StrictHostKeyChecking invalid\n")
+	reconnect()
+	testTunnel(t, "localhost:49711", "localhost:49712")
+
+	// Changed port, re-connecting fails
+	writeConf("    StrictHostKeyChecking no\n    Port 1\n")
+	reconnect()
+	c, out, err = cliCommand(env, "list")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(stripANSI(out)), "\n")
+	if strings.Fields(lines[1])[0] != "reconn" {
+		t.Errorf("test tunnel not reconnecting in list: %s", out)
+	}
+
+	// Fixed again
+	writeConf("")
+	time.Sleep(2500 * time.Millisecond)
+	testTunnel(t, "localhost:49711", "localhost:49712")
+}
+
 func TestTunnelReconnectAbort(t *testing.T) {
 	env, cancel, err := makeDefaultEnvWithDaemon(t)
 	if err != nil {
@@ -812,6 +876,152 @@ func TestTunnelJump(t *testing.T) {
 	testTunnel(t, "localhost:49711", "localhost:49712")
 }
 
+func TestTunnelHostDefinitions(t *testing.T) {
+	dir := t.TempDir()
+	cfg := defaultConfig
+	// Hosts are defined in the boring config only
+	cfg.sshConfig = filepath.Join(dir, "ssh_config")
+	if err := os.WriteFile(cfg.sshConfig, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg.boringConfig = filepath.Join(dir, "config.toml")
+	conf := `[[hosts]]
+name = "bastion"
+hostname = "127.0.0.1"
+port = 58391
+user = "jump"
+identity = "../testdata/keys/client"
+known_hosts = "../testdata/known_hosts/known_hosts"
+
+[[hosts]]
+name = "target"
+hostname = "127.0.0.1"
+port = 58391
+user = "test"
+identity = ["../testdata/keys/client"]
+known_hosts = "../testdata/known_hosts/known_hosts"
+strict_host_key_checking = "yes"
+jump = ["bastion", "bastion"]
+
+[[tunnels]]
+name = "test"
+host = "target"
+local = "localhost:49711"
+remote = "localhost:49712"
+`
+	if err := os.WriteFile(cfg.boringConfig, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	testTunnel(t, "localhost:49711", "localhost:49712")
+}
+
+func TestTunnelVia(t *testing.T) {
+	cfg := defaultConfig
+	cfg.debug = true
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	// The tunnel connected through is opened first
+	c, out, err := cliCommand(env, "open", "test-via")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	testTunnel(t, "localhost:49711", "localhost:49712")
+
+	_, out, err = cliCommand(env, "list")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if !strings.Contains(out, "test-socks") {
+		t.Errorf("via tunnel not running: %s", out)
+	}
+	b, err := os.ReadFile(getEnv(env, "BORING_LOG_FILE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "test-via: opened tunnel test-socks to connect through") {
+		t.Errorf("via tunnel not opened first: %s", b)
+	}
+	if !strings.Contains(string(b), "test-via: connecting via tunnel test-socks") {
+		t.Errorf("did not connect via tunnel: %s", b)
+	}
+
+	// Re-connecting the tunnel connected through re-connects the dependent
+	server.pause()
+	server.closeAll()
+	server.resume()
+	time.Sleep(time.Second)
+	testTunnel(t, "localhost:49711", "localhost:49712")
+
+	// Closing the tunnel connected through closes the dependent
+	if c, out, err = cliCommand(env, "close", "test-socks"); err != nil || c != 0 {
+		t.Fatalf("could not close tunnel: %v, %s", err, out)
+	}
+	_, out, err = cliCommand(env, "list")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	for _, l := range strings.Split(stripANSI(out), "\n") {
+		if f := strings.Fields(l); len(f) > 1 && f[1] == "test-via" && f[0] != "closed" {
+			t.Errorf("dependent tunnel not closed: %s", out)
+		}
+	}
+}
+
+func TestTunnelViaModes(t *testing.T) {
+	for name, via := range map[string]string{
+		"test-via-socks":   "test-socks",
+		"test-via-forward": "test-ssh-forward",
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := defaultConfig
+			cfg.debug = true
+			env, cancel, err := makeEnvWithDaemon(cfg, t)
+			if err != nil {
+				t.Fatalf("%v", err.Error())
+			}
+			defer cancel()
+
+			c, out, err := cliCommand(env, "open", name)
+			if err != nil {
+				t.Fatalf("failed to run CLI command: %v", err)
+			}
+			if c != 0 {
+				t.Fatalf("exit code %d: %s", c, out)
+			}
+			testTunnel(t, "localhost:49711", "localhost:49712")
+
+			b, err := os.ReadFile(getEnv(env, "BORING_LOG_FILE"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(b), name+": connecting via tunnel "+via) {
+				t.Errorf("did not connect via tunnel: %s", b)
+			}
+		})
+	}
+}
+
 func TestTunnelSocks(t *testing.T) {
 	env, cancel, err := makeDefaultEnvWithDaemon(t)
 	if err != nil {
@@ -916,6 +1126,45 @@ func TestTunnelKeepAlive(t *testing.T) {
 	}
 }
 
+func TestTunnelKeepAliveCountMax(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test-keepalive-deaf")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	// The first unanswered keep-alive is tolerated
+	time.Sleep(2500 * time.Millisecond)
+	logs, err := os.ReadFile(getEnv(env, "BORING_LOG_FILE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(logs), "did not answer keep-alive (1/2)") {
+		t.Errorf("missed keep-alive not logged: %s", logs)
+	}
+	if strings.Contains(string(logs), "disconnecting") {
+		t.Fatalf("disconnected after a single missed keep-alive: %s", logs)
+	}
+
+	// The second one triggers a re-connect
+	time.Sleep(time.Second)
+	logs, err = os.ReadFile(getEnv(env, "BORING_LOG_FILE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(logs), "did not answer 2 keep-alive(s), disconnecting") {
+		t.Errorf("expected disconnect after two missed keep-alives: %s", logs)
+	}
+}
+
 // Test connecting to a server that presents an SSH host certificate,
 // trusted via an @cert-authority known_hosts entry.
 func TestTunnelHostCert(t *testing.T) {
@@ -999,3 +1248,489 @@ func TestTunnelIgnoreWrongCert(t *testing.T) {
 		t.Fatalf("exit code %d: %s", c, out)
 	}
 }
+
+// Test a tunnel carrying multiple forwards over one connection
+func TestTunnelMultiForwards(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test-multi")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	testTunnel(t, "localhost:49711", "localhost:49712")
+	testTunnel(t, "localhost:49713", "localhost:49714")
+
+	socksDialer, err := xproxy.SOCKS5("tcp", "localhost:49717", nil, xproxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := makeListener("localhost:49718")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer l.Close()
+	conn, err := socksDialer.Dial("tcp", "localhost:49718")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := testConnected(l, conn); err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+
+	// Additional forwards are listed below their tunnel
+	c, out, err = cliCommand(env, "list")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	lines := strings.Split(strings.TrimSpace(stripANSI(out)), "\n")
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[1] != "test-multi" {
+			continue
+		}
+		if i+2 >= len(lines) ||
+			!reflect.DeepEqual(strings.Fields(lines[i+1]),
+				[]string{"localhost:49713", "->", "localhost:49714"}) ||
+			!reflect.DeepEqual(strings.Fields(lines[i+2]),
+				[]string{"localhost:49717", "->", "[SOCKS]"}) {
+			t.Errorf("forwards not listed below tunnel: %s", out)
+		}
+		return
+	}
+	t.Errorf("tunnel not in list output: %s", out)
+}
+
+// Test that tunnels to the same host share one connection, and that
+// closing one of them keeps the connection for the others
+func TestTunnelSharedConn(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	time.Sleep(50 * time.Millisecond) // Let connections of previous tests settle
+	n := server.numConns()
+
+	c, out, err := cliCommand(env, "open", "test", "test2")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	if m := server.numConns(); m != n+1 {
+		t.Fatalf("expected %d connection(s), got %d", n+1, m)
+	}
+
+	c, out, err = cliCommand(env, "close", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	testTunnel(t, "localhost:49713", "localhost:49714")
+}
+
+func TestListStats(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	testTunnel(t, "localhost:49711", "localhost:49712")
+	time.Sleep(50 * time.Millisecond) // Let the connection wind down
+
+	c, out, err = cliCommand(env, "list", "--stats")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	lines := strings.Split(strings.TrimSpace(stripANSI(out)), "\n")
+	header := strings.Fields(lines[0])
+	if !reflect.DeepEqual(header[len(header)-6:],
+		[]string{"Conns", "Total", "Sent", "Recv", "Fails", "Reconns"}) {
+		t.Fatalf("list output did not contain stats header: %s", out)
+	}
+	fields := strings.Fields(lines[1])
+	if !reflect.DeepEqual(fields[len(fields)-6:],
+		[]string{"0", "1", fmt.Sprintf("%dB", len(testMsg)), "0B", "0", "0"}) {
+		t.Errorf("incorrect stats in list output: %s", out)
+	}
+}
+
+func listStatus(t *testing.T, env []string, name string) string {
+	c, out, err := cliCommand(env, "list")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	for _, line := range strings.Split(stripANSI(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[1] == name {
+			return fields[0]
+		}
+	}
+	t.Fatalf("tunnel %s not in list output: %s", name, out)
+	return ""
+}
+
+// Test that lazy tunnels connect on first use, and disconnect when idle
+func TestTunnelLazy(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	time.Sleep(50 * time.Millisecond) // Let connections of previous tests settle
+	n := server.numConns()
+
+	c, out, err := cliCommand(env, "open", "test-lazy")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	if s := listStatus(t, env, "test-lazy"); s != "idle" {
+		t.Fatalf("expected idle tunnel, got %s", s)
+	}
+	if m := server.numConns(); m != n {
+		t.Fatalf("expected %d connection(s), got %d", n, m)
+	}
+
+	testTunnel(t, "localhost:49711", "localhost:49712")
+	if s := listStatus(t, env, "test-lazy"); s == "idle" {
+		t.Fatalf("expected connected tunnel, got %s", s)
+	}
+
+	time.Sleep(1500 * time.Millisecond) // lazy_idle is 1s
+	if s := listStatus(t, env, "test-lazy"); s != "idle" {
+		t.Fatalf("expected idle tunnel after timeout, got %s", s)
+	}
+
+	// Connects again on demand
+	testTunnel(t, "localhost:49711", "localhost:49712")
+}
+
+func TestTunnelTTL(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test", "--for", "1s")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	re := regexp.MustCompile(`^\d{2}m\d{2}s/\d{2}m\d{2}s$`)
+	if s := listStatus(t, env, "test"); !re.MatchString(s) {
+		t.Errorf("remaining time not in status: %s", s)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+	if s := listStatus(t, env, "test"); s != "closed" {
+		t.Errorf("expected closed tunnel after time limit, got %s", s)
+	}
+}
+
+func TestTunnelIdleTimeout(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test-idle")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	// Activity keeps the tunnel open
+	time.Sleep(700 * time.Millisecond)
+	testTunnel(t, "localhost:49711", "localhost:49712")
+	time.Sleep(700 * time.Millisecond)
+	if s := listStatus(t, env, "test-idle"); s == "closed" {
+		t.Fatalf("tunnel closed despite activity")
+	}
+
+	time.Sleep(800 * time.Millisecond)
+	if s := listStatus(t, env, "test-idle"); s != "closed" {
+		t.Errorf("expected closed tunnel after idle timeout, got %s", s)
+	}
+}
+
+func TestOpenForInvalid(t *testing.T) {
+	env, err := makeDefaultEnv(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+
+	c, out, err := cliCommand(env, "open", "test", "--for", "soon")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 1 {
+		t.Fatalf("exit code %d, should be 1", c)
+	}
+	if !strings.Contains(out, "Invalid duration 'soon'") {
+		t.Errorf("output did not indicate invalid duration: %s", out)
+	}
+}
+
+func TestTunnelReconnectGiveUp(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test-giveup")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	time.Sleep(50 * time.Millisecond) // Give the tunnel some time to establish
+
+	server.pause()
+	server.closeAll()
+	time.Sleep(300 * time.Millisecond) // Plenty of time for both attempts
+
+	if s := listStatus(t, env, "test-giveup"); s != "failed" {
+		t.Fatalf("expected failed tunnel, got %s", s)
+	}
+	_, out, _ = cliCommand(env, "list")
+	if !strings.Contains(out, "gave up after 2 attempts") {
+		t.Errorf("last error not shown in list: %s", out)
+	}
+
+	// Failed tunnels can be opened again
+	server.resume()
+	c, out, err = cliCommand(env, "open", "test-giveup")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 || !strings.Contains(out, "Opened tunnel") {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	testTunnel(t, "localhost:49711", "localhost:49712")
+
+	c, out, err = cliCommand(env, "close", "test-giveup")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+}
+
+func TestTunnelReconnectHold(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	time.Sleep(50 * time.Millisecond) // Give the tunnel some time to establish
+
+	server.pause()
+	server.closeAll()
+	time.Sleep(50 * time.Millisecond)
+
+	if s := listStatus(t, env, "test"); s != "reconn" {
+		t.Fatalf("expected reconnecting tunnel, got %s", s)
+	}
+
+	// The local listener stays open while re-connecting
+	l, err := makeListener("localhost:49712")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer l.Close()
+	conn, err := dial("localhost:49711")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer conn.Close()
+
+	// The held connection is forwarded once re-connected
+	server.resume()
+	if err := testConnected(l, conn); err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+}
+
+func TestTunnelHealthCheck(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test-health")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	// Nothing is listening behind the tunnel yet
+	time.Sleep(300 * time.Millisecond)
+	if s := listStatus(t, env, "test-health"); s != "degraded" {
+		t.Fatalf("expected degraded tunnel, got %s", s)
+	}
+	_, out, _ = cliCommand(env, "list")
+	if !strings.Contains(out, "health check failed") {
+		t.Errorf("health check error not shown in list: %s", out)
+	}
+
+	// Echo server satisfying the check
+	l, err := net.Listen("tcp", "localhost:49712")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	time.Sleep(300 * time.Millisecond)
+	if s := listStatus(t, env, "test-health"); s == "degraded" {
+		t.Fatalf("tunnel still degraded")
+	}
+}
+
+func TestTunnelFailover(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test-failover")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	if !strings.Contains(out, "via 127.0.0.1.") {
+		t.Errorf("host in use not shown: %s", out)
+	}
+
+	testTunnel(t, "localhost:49711", "localhost:49712")
+
+	// The host in use is shown in the Via column
+	c, out, err = cliCommand(env, "list")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	for _, line := range strings.Split(stripANSI(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[1] == "test-failover" {
+			if via := fields[len(fields)-1]; via != "127.0.0.1" {
+				t.Errorf("incorrect host in Via column: %s", via)
+			}
+		}
+	}
+}
+
+func TestTunnelEphemeral(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test-ephemeral")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	// Bound addresses are shown in list, in the local and remote column
+	c, out, err = cliCommand(env, "list")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	var local, remote string
+	lines := strings.Split(stripANSI(out), "\n")
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[1] == "test-ephemeral" {
+			local = fields[2]
+			remote = strings.Fields(lines[i+1])[2]
+		}
+	}
+	if local == "" || strings.HasSuffix(local, ":0") || remote == "" || strings.HasSuffix(remote, ":0") {
+		t.Fatalf("bound addresses not shown: %s", out)
+	}
+
+	testTunnel(t, local, "localhost:49712")
+	testTunnel(t, remote, "localhost:49714")
+}